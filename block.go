@@ -31,8 +31,16 @@ func (p *parser) block(out *bytes.Buffer, data []byte) {
 	}
 	p.nesting++
 
+	if p.flags&EXTENSION_COMMONMARK != 0 {
+		p.commonMarkBlock(out, data)
+		p.nesting--
+		return
+	}
+
 	// parse out one block-level construct at a time
 	for len(data) > 0 {
+		p.flushBlocks(out)
+
 		// prefixed header:
 		//
 		// # Header 1
@@ -100,10 +108,11 @@ func (p *parser) block(out *bytes.Buffer, data []byte) {
 		// or
 		// ______
 		if p.isHRule(data) {
-			p.r.HRule(out)
 			var i int
 			for i = 0; data[i] != '\n'; i++ {
 			}
+			p.at(data, 0, i)
+			p.r.HRule(out)
 			data = data[i:]
 			continue
 		}
@@ -195,6 +204,7 @@ func (p *parser) prefixHeader(out *bytes.Buffer, data []byte) int {
 	if end > i {
 		work := func() bool {
 			p.inline(out, data[i:end])
+			p.at(data, 0, skip)
 			return true
 		}
 		p.r.Header(out, work, level)
@@ -330,6 +340,7 @@ func (p *parser) html(out *bytes.Buffer, data []byte, doRender bool) int {
 		for end > 0 && data[end-1] == '\n' {
 			end--
 		}
+		p.at(data, 0, end)
 		p.r.BlockHtml(out, data[:end])
 	}
 
@@ -364,6 +375,7 @@ func (p *parser) htmlComment(out *bytes.Buffer, data []byte, doRender bool) int
 			for end > 0 && data[end-1] == '\n' {
 				end--
 			}
+			p.at(data, 0, end)
 			p.r.BlockHtml(out, data[:end])
 		}
 		return size
@@ -397,6 +409,7 @@ func (p *parser) htmlHr(out *bytes.Buffer, data []byte, doRender bool) int {
 				for end > 0 && data[end-1] == '\n' {
 					end--
 				}
+				p.at(data, 0, end)
 				p.r.BlockHtml(out, data[:end])
 			}
 			return size
@@ -592,6 +605,7 @@ func (p *parser) fencedCode(out *bytes.Buffer, data []byte) int {
 	}
 
 	var work bytes.Buffer
+	p.codeAt(data, beg)
 
 	for {
 		// safe to assume beg < len(data)
@@ -625,6 +639,7 @@ func (p *parser) fencedCode(out *bytes.Buffer, data []byte) int {
 		syntax = *lang
 	}
 
+	p.at(data, 0, beg)
 	p.r.BlockCodeStart(out, work.Bytes(), syntax)
 	p.r.BlockCodeBody(out, work.Bytes(), syntax)
 	p.r.BlockCodeEnd(out, work.Bytes(), syntax)
@@ -659,6 +674,7 @@ func (p *parser) table(out *bytes.Buffer, data []byte) int {
 		p.tableRow(&body, data[rowStart:i], columns)
 	}
 
+	p.at(data, 0, i)
 	p.r.Table(out, header.Bytes(), body.Bytes(), columns)
 
 	return i
@@ -808,16 +824,19 @@ func (p *parser) tableRow(out *bytes.Buffer, data []byte, columns []int) {
 
 		var cellWork bytes.Buffer
 		p.inline(&cellWork, data[cellStart:cellEnd])
+		p.at(data, cellStart, cellEnd)
 		p.r.TableCell(&rowWork, cellWork.Bytes(), columns[col])
 	}
 
 	// pad it out with empty columns to get the right number
 	for ; col < len(columns); col++ {
+		p.at(data, len(data), len(data))
 		p.r.TableCell(&rowWork, nil, columns[col])
 	}
 
 	// silently ignore rows with too many cells
 
+	p.at(data, 0, len(data))
 	p.r.TableRow(out, rowWork.Bytes())
 }
 
@@ -839,6 +858,7 @@ func (p *parser) quotePrefix(data []byte) int {
 // parse a blockquote fragment
 func (p *parser) quote(out *bytes.Buffer, data []byte) int {
 	var raw bytes.Buffer
+	var offsets []int
 	beg, end := 0, 0
 	for beg < len(data) {
 		end = beg
@@ -860,11 +880,15 @@ func (p *parser) quote(out *bytes.Buffer, data []byte) int {
 
 		// this line is part of the blockquote
 		raw.Write(data[beg:end])
+		offsets = p.appendOffsets(offsets, data, beg, end)
 		beg = end
 	}
 
 	var cooked bytes.Buffer
+	p.pushSource(raw.Bytes(), append(offsets, p.sourceOffset(data, end)))
 	p.block(&cooked, raw.Bytes())
+	p.popSource()
+	p.at(data, 0, end)
 	p.r.BlockQuote(out, cooked.Bytes())
 	return end
 }
@@ -880,7 +904,8 @@ func (p *parser) codePrefix(data []byte) int {
 func (p *parser) code(out *bytes.Buffer, data []byte) int {
 	var work bytes.Buffer
 
-	i := 0
+	// the end of the last line that isn't blank, where the block ends
+	i, last := 0, 0
 	for i < len(data) {
 		beg := i
 		for data[i] != '\n' {
@@ -898,10 +923,14 @@ func (p *parser) code(out *bytes.Buffer, data []byte) int {
 		}
 
 		// verbatim copy to the working buffeu
+		if work.Len() == 0 {
+			p.codeAt(data, beg)
+		}
 		if blankline {
 			work.WriteByte('\n')
 		} else {
 			work.Write(data[beg:i])
+			last = i
 		}
 	}
 
@@ -917,6 +946,7 @@ func (p *parser) code(out *bytes.Buffer, data []byte) int {
 
 	work.WriteByte('\n')
 
+	p.at(data, 0, last)
 	p.r.BlockCodeStart(out, work.Bytes(), "")
 	p.r.BlockCodeBody(out, work.Bytes(), "")
 	p.r.BlockCodeEnd(out, work.Bytes(), "")
@@ -977,6 +1007,7 @@ func (p *parser) list(out *bytes.Buffer, data []byte, flags int) int {
 			}
 			flags &= ^LIST_ITEM_BEGINNING_OF_LIST
 		}
+		p.at(data, 0, i)
 		return true
 	}
 
@@ -1014,9 +1045,11 @@ func (p *parser) listItem(out *bytes.Buffer, data []byte, flags *int) int {
 
 	// get working buffer
 	var raw bytes.Buffer
+	var offsets []int
 
 	// put the first line into the working buffer
 	raw.Write(data[line:i])
+	offsets = p.appendOffsets(offsets, data, line, i)
 	line = i
 
 	// process the following lines
@@ -1089,6 +1122,7 @@ gatherlines:
 		// a blank line means this should be parsed as a block
 		case containsBlankLine:
 			raw.WriteByte('\n')
+			offsets = append(offsets, p.sourceOffset(data, line-1))
 			*flags |= LIST_ITEM_CONTAINS_BLOCK
 		}
 
@@ -1097,15 +1131,18 @@ gatherlines:
 		if containsBlankLine {
 			containsBlankLine = false
 			raw.WriteByte('\n')
+			offsets = append(offsets, p.sourceOffset(data, line-1))
 		}
 
 		// add the line into the working buffer without prefix
 		raw.Write(data[line+indent : i])
+		offsets = p.appendOffsets(offsets, data, line+indent, i)
 
 		line = i
 	}
 
 	rawBytes := raw.Bytes()
+	p.pushSource(rawBytes, append(offsets, p.sourceOffset(data, line)))
 
 	// render the contents of the list item
 	var cooked bytes.Buffer
@@ -1127,6 +1164,8 @@ gatherlines:
 		}
 	}
 
+	p.popSource()
+
 	// render the actual list item
 	cookedBytes := cooked.Bytes()
 	parsedEnd := len(cookedBytes)
@@ -1135,11 +1174,20 @@ gatherlines:
 	for parsedEnd > 0 && cookedBytes[parsedEnd-1] == '\n' {
 		parsedEnd--
 	}
+	p.at(data, 0, line)
 	p.r.ListItem(out, cookedBytes[:parsedEnd], *flags)
 
 	return line
 }
 
+// Hand the top-level blocks finished so far over to be rendered, if the
+// document is being streamed
+func (p *parser) flushBlocks(out *bytes.Buffer) {
+	if p.flush != nil && out == p.document {
+		p.flush(out)
+	}
+}
+
 // render a single paragraph that has already been parsed out
 func (p *parser) renderParagraph(out *bytes.Buffer, data []byte) {
 	if len(data) == 0 {
@@ -1160,8 +1208,15 @@ func (p *parser) renderParagraph(out *bytes.Buffer, data []byte) {
 		end--
 	}
 
+	// a tight list item's paragraphs are just their text
+	if p.nesting == p.tightNesting {
+		p.inline(out, data[beg:end])
+		return
+	}
+
 	work := func() bool {
 		p.inline(out, data[beg:end])
+		p.at(data, beg, end)
 		return true
 	}
 	p.r.Paragraph(out, work)
@@ -1191,6 +1246,7 @@ func (p *parser) paragraph(out *bytes.Buffer, data []byte) int {
 			if level := p.isUnderlinedHeader(current); level > 0 {
 				// render the paragraph
 				p.renderParagraph(out, data[:prev])
+				start := prev
 
 				// ignore leading and trailing whitespace
 				eol := i - 1
@@ -1201,20 +1257,22 @@ func (p *parser) paragraph(out *bytes.Buffer, data []byte) int {
 					eol--
 				}
 
+				// find the end of the underline
+				for data[i] != '\n' {
+					i++
+				}
+
 				// render the header
 				// this ugly double closure avoids forcing variables onto the heap
-				work := func(o *bytes.Buffer, pp *parser, d []byte) func() bool {
+				work := func(o *bytes.Buffer, pp *parser, d, h []byte) func() bool {
 					return func() bool {
 						pp.inline(o, d)
+						pp.at(h, 0, len(h))
 						return true
 					}
-				}(out, p, data[prev:eol])
+				}(out, p, data[prev:eol], data[start:i])
 				p.r.Header(out, work, level)
 
-				// find the end of the underline
-				for data[i] != '\n' {
-					i++
-				}
 				return i
 			}
 		}
@@ -970,3 +970,32 @@ func TestFencedCodeBlock_EXTENSION_NO_EMPTY_LINE_BEFORE_BLOCK(t *testing.T) {
 	}
 	doTestsBlock(t, tests, EXTENSION_FENCED_CODE|EXTENSION_NO_EMPTY_LINE_BEFORE_BLOCK)
 }
+
+func TestParserOptions(t *testing.T) {
+	renderer := HtmlRenderer(HTML_USE_XHTML, "", "")
+	var tests = []struct {
+		input    string
+		options  ParserOptions
+		expected string
+	}{
+		// zero values are the defaults
+		{"\tcode\n", ParserOptions{}, "<pre><code>code\n</code></pre>\n"},
+		{"> > > deep\n", ParserOptions{}, "<blockquote>\n<blockquote>\n<blockquote>\n<p>deep</p>\n</blockquote>\n</blockquote>\n</blockquote>\n"},
+
+		// a tab that doesn't reach four columns isn't code
+		{"\tcode\n", ParserOptions{TabSize: 2}, "<p>code</p>\n"},
+		{"  \tcode\n", ParserOptions{TabSize: 2}, "<pre><code>code\n</code></pre>\n"},
+		{"-\tx\ty\n", ParserOptions{TabSize: 8}, "<ul>\n<li>x       y</li>\n</ul>\n"},
+		{"-\tx\ty\n", ParserOptions{Extensions: EXTENSION_TAB_SIZE_EIGHT}, "<ul>\n<li>x       y</li>\n</ul>\n"},
+
+		// anything nested too deeply is dropped
+		{"> > > deep\n", ParserOptions{MaxNesting: 2}, "<blockquote>\n<blockquote>\n</blockquote>\n</blockquote>\n"},
+	}
+	for _, test := range tests {
+		actual := string(MarkdownWithOptions([]byte(test.input), renderer, test.options))
+		if actual != test.expected {
+			t.Errorf("\nInput   [%#v] (%+v)\nExpected[%#v]\nActual  [%#v]",
+				test.input, test.options, test.expected, actual)
+		}
+	}
+}
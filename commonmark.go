@@ -0,0 +1,1177 @@
+//
+// Functions to parse the CommonMark dialect (EXTENSION_COMMONMARK).
+//
+// CommonMark pins down the places where Markdown 1.0.3 is vague: which lines
+// belong to a list item, when a run of '*' or '_' opens or closes emphasis,
+// where link reference definitions can appear and how far an HTML block
+// reaches. The functions here replace the matching parts of the parser when
+// the extension is on; everything else is shared.
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Parse block-level data the CommonMark way
+func (p *parser) commonMarkBlock(out *bytes.Buffer, data []byte) {
+	blank, started := false, false
+	for len(data) > 0 {
+		p.flushBlocks(out)
+
+		// blank lines between blocks make the list item they are in loose
+		if i := p.isEmpty(data); i > 0 {
+			blank = started
+			data = data[i:]
+			continue
+		}
+		if blank && p.nesting == p.looseNesting {
+			p.loose = true
+		}
+
+		var i int
+		switch {
+		case p.codePrefix(data) > 0:
+			i = p.code(out, data)
+
+		case p.commonMarkFence(data) != nil:
+			i = p.commonMarkFencedCode(out, data)
+
+		case p.commonMarkHtmlStart(data) > 0:
+			i = p.commonMarkHtml(out, data)
+
+		case p.isCommonMarkHeader(data):
+			i = p.commonMarkHeader(out, data)
+
+		case p.isHRule(data):
+			for data[i] != '\n' {
+				i++
+			}
+			p.at(data, 0, i)
+			p.r.HRule(out)
+			i++
+
+		case p.quotePrefix(data) > 0:
+			i = p.commonMarkQuote(out, data)
+
+		case p.commonMarkListMarker(data) > 0:
+			i = p.commonMarkList(out, data)
+
+		case p.flags&EXTENSION_TABLES != 0 && p.table(out, data) > 0:
+			i = p.table(out, data)
+
+		default:
+			i = p.commonMarkParagraph(out, data)
+		}
+
+		blank = endsWithBlankLine(data[:i])
+		started = true
+		data = data[i:]
+	}
+}
+
+// Check whether the last line of data is blank
+func endsWithBlankLine(data []byte) bool {
+	if len(data) < 2 {
+		return false
+	}
+	line := bytes.LastIndexByte(data[:len(data)-1], '\n') + 1
+	return len(bytes.Trim(data[line:], " \t\n")) == 0
+}
+
+// Check whether data starts with a line that ends a paragraph before it
+func (p *parser) commonMarkInterrupts(data []byte) bool {
+	if p.isCommonMarkHeader(data) || p.isHRule(data) || p.quotePrefix(data) > 0 ||
+		p.commonMarkFence(data) != nil {
+		return true
+	}
+	if kind := p.commonMarkHtmlStart(data); kind > 0 && kind < 7 {
+		return true
+	}
+
+	// only a list that starts at one, with something in its first item,
+	// can interrupt a paragraph
+	if width := p.commonMarkListMarker(data); width > 0 {
+		kind, start := p.commonMarkListKind(data)
+		return (kind != '.' && kind != ')' || start == 1) && !p.commonMarkEmptyItem(data, width)
+	}
+	return false
+}
+
+//
+// Paragraphs and headers
+//
+
+func (p *parser) commonMarkParagraph(out *bytes.Buffer, data []byte) int {
+	// link reference definitions can only start a paragraph
+	if i := p.commonMarkReference(data); i > 0 {
+		return i
+	}
+
+	end := 0
+	for end < len(data) {
+		eol := end + bytes.IndexByte(data[end:], '\n') + 1
+		if p.isEmpty(data[end:eol]) > 0 {
+			break
+		}
+		if end > 0 {
+			// an underline turns the whole paragraph into a header
+			if level := p.commonMarkUnderline(data[end:eol]); level > 0 {
+				p.commonMarkSetextHeader(out, data[:eol], data[:end], level)
+				return eol
+			}
+			if p.commonMarkInterrupts(data[end:]) {
+				break
+			}
+		}
+		end = eol
+	}
+
+	// the lines of a paragraph don't keep their indentation
+	text, offsets := p.commonMarkUnindent(data[:end])
+	p.pushSource(text, offsets)
+	p.renderParagraph(out, text)
+	p.popSource()
+	return end
+}
+
+// Return lines without their leading spaces, with the input offset of each
+// byte that is left
+func (p *parser) commonMarkUnindent(lines []byte) ([]byte, []int) {
+	var text []byte
+	var offsets []int
+	for beg := 0; beg < len(lines); {
+		end := beg + bytes.IndexByte(lines[beg:], '\n') + 1
+		for lines[beg] == ' ' {
+			beg++
+		}
+		text = append(text, lines[beg:end]...)
+		offsets = p.appendOffsets(offsets, lines, beg, end)
+		beg = end
+	}
+	return text, append(offsets, p.sourceOffset(lines, len(lines)))
+}
+
+// Return the level of the header that data underlines, or 0 if it isn't an
+// underline
+func (p *parser) commonMarkUnderline(data []byte) int {
+	i := 0
+	for i < 3 && data[i] == ' ' {
+		i++
+	}
+	c := data[i]
+	if c != '=' && c != '-' {
+		return 0
+	}
+	for data[i] == c {
+		i++
+	}
+	for data[i] == ' ' {
+		i++
+	}
+	switch {
+	case data[i] != '\n':
+		return 0
+	case c == '=':
+		return 1
+	}
+	return 2
+}
+
+func (p *parser) commonMarkSetextHeader(out *bytes.Buffer, data, text []byte, level int) {
+	text, offsets := p.commonMarkUnindent(text)
+	end := len(text)
+	for end > 0 && (text[end-1] == '\n' || text[end-1] == ' ') {
+		end--
+	}
+	work := func() bool {
+		p.pushSource(text, offsets)
+		p.inline(out, text[:end])
+		p.popSource()
+		p.at(data, 0, len(data))
+		return true
+	}
+	p.r.Header(out, work, level)
+}
+
+func (p *parser) isCommonMarkHeader(data []byte) bool {
+	i := 0
+	for i < 3 && data[i] == ' ' {
+		i++
+	}
+	level := 0
+	for level < 7 && data[i+level] == '#' {
+		level++
+	}
+	c := data[i+level]
+	return level > 0 && level < 7 && (c == ' ' || c == '\n')
+}
+
+func (p *parser) commonMarkHeader(out *bytes.Buffer, data []byte) int {
+	beg := 0
+	for data[beg] == ' ' {
+		beg++
+	}
+	level := 0
+	for data[beg] == '#' {
+		beg++
+		level++
+	}
+	skip := beg + bytes.IndexByte(data[beg:], '\n')
+	for beg < skip && data[beg] == ' ' {
+		beg++
+	}
+
+	// drop the optional closing sequence of #s, which must follow a space
+	end := skip
+	for end > beg && data[end-1] == ' ' {
+		end--
+	}
+	closing := end
+	for closing > beg && data[closing-1] == '#' {
+		closing--
+	}
+	if closing == beg || data[closing-1] == ' ' {
+		end = closing
+	}
+	for end > beg && data[end-1] == ' ' {
+		end--
+	}
+
+	work := func() bool {
+		p.inline(out, data[beg:end])
+		p.at(data, 0, skip)
+		return true
+	}
+	p.r.Header(out, work, level)
+	return skip + 1
+}
+
+//
+// Fenced code
+//
+
+// Return the opening fence of a fenced code block that data starts with, or
+// nil if there isn't one
+func (p *parser) commonMarkFence(data []byte) []byte {
+	i := 0
+	for i < 3 && data[i] == ' ' {
+		i++
+	}
+	c := data[i]
+	if c != '`' && c != '~' {
+		return nil
+	}
+	end := i
+	for data[end] == c {
+		end++
+	}
+	if end-i < 3 {
+		return nil
+	}
+
+	// the info string after backticks can't have backticks of its own
+	if c == '`' && bytes.IndexByte(data[end:bytes.IndexByte(data, '\n')], '`') >= 0 {
+		return nil
+	}
+	return data[i:end]
+}
+
+// Check whether data starts with a line that closes the code block opened by
+// fence
+func (p *parser) isCommonMarkFenceEnd(data, fence []byte) bool {
+	i := 0
+	for i < 3 && data[i] == ' ' {
+		i++
+	}
+	n := 0
+	for data[i] == fence[0] {
+		i++
+		n++
+	}
+	for data[i] == ' ' {
+		i++
+	}
+	return n >= len(fence) && data[i] == '\n'
+}
+
+func (p *parser) commonMarkFencedCode(out *bytes.Buffer, data []byte) int {
+	fence := p.commonMarkFence(data)
+	indent := 0
+	for data[indent] == ' ' {
+		indent++
+	}
+
+	// the language is the first word of the info string
+	eol := bytes.IndexByte(data, '\n')
+	info := bytes.Fields(data[indent+len(fence) : eol])
+	lang := ""
+	if len(info) > 0 {
+		var buf bytes.Buffer
+		unescapeText(&buf, info[0])
+		lang = buf.String()
+	}
+
+	// the code runs to the closing fence, or to the end of the container if
+	// there isn't one
+	var work bytes.Buffer
+	end := eol + 1
+	p.codeAt(data, end)
+	for end < len(data) {
+		eol = end + bytes.IndexByte(data[end:], '\n') + 1
+		if p.isCommonMarkFenceEnd(data[end:], fence) {
+			end = eol
+			break
+		}
+
+		// lines lose as much indentation as the opening fence had
+		beg := end
+		for beg < end+indent && data[beg] == ' ' {
+			beg++
+		}
+		if work.Len() == 0 {
+			p.codeAt(data, beg)
+		}
+		work.Write(data[beg:eol])
+		end = eol
+	}
+
+	p.at(data, 0, end)
+	p.r.BlockCodeStart(out, work.Bytes(), lang)
+	p.r.BlockCodeBody(out, work.Bytes(), lang)
+	p.r.BlockCodeEnd(out, work.Bytes(), lang)
+	return end
+}
+
+//
+// HTML blocks
+//
+
+// The tags that start an HTML block that ends at a blank line
+var commonMarkHtmlTags = map[string]bool{
+	"address": true, "article": true, "aside": true, "base": true,
+	"basefont": true, "blockquote": true, "body": true, "caption": true,
+	"center": true, "col": true, "colgroup": true, "dd": true,
+	"details": true, "dialog": true, "dir": true, "div": true, "dl": true,
+	"dt": true, "fieldset": true, "figcaption": true, "figure": true,
+	"footer": true, "form": true, "frame": true, "frameset": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"head": true, "header": true, "hr": true, "html": true, "iframe": true,
+	"legend": true, "li": true, "link": true, "main": true, "menu": true,
+	"menuitem": true, "nav": true, "noframes": true, "ol": true,
+	"optgroup": true, "option": true, "p": true, "param": true,
+	"section": true, "source": true, "summary": true, "table": true,
+	"tbody": true, "td": true, "tfoot": true, "th": true, "thead": true,
+	"title": true, "tr": true, "track": true, "ul": true,
+}
+
+// The tags whose contents can have blank lines, and what ends each of the
+// other kinds of HTML block that end at a marker rather than a blank line
+var (
+	commonMarkRawTags = []string{"script", "pre", "style", "textarea"}
+	commonMarkHtmlEnd = []string{2: "-->", 3: "?>", 4: ">", 5: "]]>"}
+)
+
+// Return the kind of HTML block that data starts with, numbered as in the
+// spec, or 0 if it doesn't start one
+func (p *parser) commonMarkHtmlStart(data []byte) int {
+	i := 0
+	for i < 3 && data[i] == ' ' {
+		i++
+	}
+	if data[i] != '<' {
+		return 0
+	}
+	data = data[i:]
+
+	switch {
+	case bytes.HasPrefix(data, []byte("<!--")):
+		return 2
+	case bytes.HasPrefix(data, []byte("<?")):
+		return 3
+	case bytes.HasPrefix(data, []byte("<![CDATA[")):
+		return 5
+	case data[1] == '!' && isletter(data[2]):
+		return 4
+	}
+
+	// find the tag name
+	beg := 1
+	if data[1] == '/' {
+		beg++
+	}
+	end := beg
+	for isalnum(data[end]) || data[end] == '-' {
+		end++
+	}
+	name := string(bytes.ToLower(data[beg:end]))
+	after := data[end]
+
+	if beg == 1 && (after == ' ' || after == '>' || after == '\n') {
+		for _, tag := range commonMarkRawTags {
+			if name == tag {
+				return 1
+			}
+		}
+	}
+	if commonMarkHtmlTags[name] &&
+		(after == ' ' || after == '>' || after == '\n' || after == '/' && data[end+1] == '>') {
+		return 6
+	}
+
+	// any other complete tag on a line of its own
+	if end > beg && isletter(data[beg]) {
+		if n := commonMarkTag(data); n > 0 && p.isEmpty(data[n:]) > 0 {
+			return 7
+		}
+	}
+	return 0
+}
+
+// Return the length of the complete HTML open or closing tag that data
+// starts with, or 0 if there isn't one
+func commonMarkTag(data []byte) int {
+	i := 1
+	closing := data[i] == '/'
+	if closing {
+		i++
+	}
+	if !isletter(data[i]) {
+		return 0
+	}
+	for i < len(data) && (isalnum(data[i]) || data[i] == '-') {
+		i++
+	}
+
+	for {
+		space := i
+		for i < len(data) && isspace(data[i]) {
+			i++
+		}
+		switch {
+		case i >= len(data):
+			return 0
+		case data[i] == '>':
+			return i + 1
+		case !closing && data[i] == '/' && i+1 < len(data) && data[i+1] == '>':
+			return i + 2
+		case closing || space == i:
+			return 0
+		}
+
+		// attribute name
+		if !isletter(data[i]) && data[i] != '_' && data[i] != ':' {
+			return 0
+		}
+		for i < len(data) && (isalnum(data[i]) || bytes.IndexByte([]byte("_.:-"), data[i]) >= 0) {
+			i++
+		}
+
+		// optional value
+		j := i
+		for j < len(data) && isspace(data[j]) {
+			j++
+		}
+		if j >= len(data) || data[j] != '=' {
+			continue
+		}
+		for j++; j < len(data) && isspace(data[j]); j++ {
+		}
+		if j >= len(data) {
+			return 0
+		}
+		switch c := data[j]; c {
+		case '"', '\'':
+			end := bytes.IndexByte(data[j+1:], c)
+			if end < 0 {
+				return 0
+			}
+			i = j + end + 2
+		default:
+			i = j
+			for i < len(data) && !isspace(data[i]) && bytes.IndexByte([]byte("\"'=<>`"), data[i]) < 0 {
+				i++
+			}
+			if i == j {
+				return 0
+			}
+		}
+	}
+}
+
+func (p *parser) commonMarkHtml(out *bytes.Buffer, data []byte) int {
+	kind := p.commonMarkHtmlStart(data)
+
+	end := 0
+	for end < len(data) {
+		eol := end + bytes.IndexByte(data[end:], '\n') + 1
+		line := bytes.ToLower(data[end:eol])
+
+		// some blocks end at a marker, the rest at a blank line
+		if kind >= 6 {
+			if p.isEmpty(line) > 0 {
+				break
+			}
+		} else if kind == 1 {
+			found := false
+			for _, tag := range commonMarkRawTags {
+				found = found || bytes.Contains(line, []byte("</"+tag+">"))
+			}
+			if found {
+				end = eol
+				break
+			}
+		} else if bytes.Contains(line, []byte(commonMarkHtmlEnd[kind])) {
+			end = eol
+			break
+		}
+		end = eol
+	}
+
+	p.at(data, 0, end)
+	p.r.BlockHtml(out, data[:end-1])
+	return end
+}
+
+//
+// Link reference definitions
+//
+
+// Check whether data starts with a link reference definition. If it does,
+// it is stored, unless there is already one with the same label, and the
+// number of bytes it takes up is returned.
+func (p *parser) commonMarkReference(data []byte) int {
+	i := 0
+	for i < 3 && data[i] == ' ' {
+		i++
+	}
+	if data[i] != '[' {
+		return 0
+	}
+
+	// label: no unescaped brackets or blank lines, and something that
+	// isn't whitespace
+	i++
+	labelBeg := i
+	for ; i < len(data) && data[i] != ']'; i++ {
+		switch {
+		case i-labelBeg >= 999 || data[i] == '[':
+			return 0
+		case data[i] == '\\' && data[i+1] != '\n':
+			i++
+		case data[i] == '\n' && p.isEmpty(data[i+1:]) > 0:
+			return 0
+		}
+	}
+	if i >= len(data) || len(bytes.TrimSpace(data[labelBeg:i])) == 0 || data[i+1] != ':' {
+		return 0
+	}
+	label := data[labelBeg:i]
+
+	// destination, which can be on the next line
+	i = p.commonMarkSpace(data, i+2)
+	var link []byte
+	if data[i] == '<' {
+		end := i + 1
+		for ; data[end] != '>'; end++ {
+			switch {
+			case data[end] == '\n' || data[end] == '<':
+				return 0
+			case data[end] == '\\':
+				end++
+			}
+		}
+		link = data[i+1 : end]
+		i = end + 1
+	} else {
+		end, depth := i, 0
+		for ; data[end] > ' ' && data[end] != 0x7f; end++ {
+			switch data[end] {
+			case '\\':
+				if ispunct(data[end+1]) {
+					end++
+				}
+			case '(':
+				depth++
+			case ')':
+				depth--
+			}
+			if depth < 0 {
+				break
+			}
+		}
+		if end == i || depth != 0 {
+			return 0
+		}
+		link = data[i:end]
+		i = end
+	}
+
+	// the definition can end after the destination
+	lineEnd := i
+	for data[lineEnd] == ' ' {
+		lineEnd++
+	}
+	if data[lineEnd] == '\n' {
+		lineEnd++
+	} else {
+		lineEnd = 0
+	}
+
+	// optional title, after some whitespace
+	var title []byte
+	end := 0
+	if j := p.commonMarkSpace(data, i); j > i {
+		title, end = p.commonMarkTitle(data, j)
+	}
+	if end == 0 {
+		title, end = nil, lineEnd
+	}
+	if end == 0 {
+		return 0
+	}
+
+	// the link is unescaped when it is used, like other references'
+	key := p.referenceKey(label)
+	if _, found := p.refs[key]; !found {
+		p.refs[key] = &reference{link: link, title: commonMarkUnescape(title)}
+	}
+	return end
+}
+
+// Remove the backslashes from escaped punctuation
+func commonMarkUnescape(text []byte) []byte {
+	var out []byte
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\\' && i+1 < len(text) && ispunct(text[i+1]) {
+			i++
+		}
+		out = append(out, text[i])
+	}
+	return out
+}
+
+// Skip spaces and at most one newline
+func (p *parser) commonMarkSpace(data []byte, i int) int {
+	for data[i] == ' ' {
+		i++
+	}
+	if data[i] == '\n' && i+1 < len(data) && p.isEmpty(data[i+1:]) == 0 {
+		i++
+		for data[i] == ' ' {
+			i++
+		}
+	}
+	return i
+}
+
+// Parse the link title that data[i:] starts with, which must be all that is
+// left on its last line. Returns the title and where its line ends, or 0 if
+// there isn't a title.
+func (p *parser) commonMarkTitle(data []byte, i int) ([]byte, int) {
+	closer := data[i]
+	switch closer {
+	case '(':
+		closer = ')'
+	case '"', '\'':
+	default:
+		return nil, 0
+	}
+
+	end := i + 1
+	for ; end < len(data) && data[end] != closer; end++ {
+		switch {
+		case data[end] == '\\':
+			end++
+		case data[i] == '(' && data[end] == '(':
+			return nil, 0
+		case data[end] == '\n' && p.isEmpty(data[end+1:]) > 0:
+			return nil, 0
+		}
+	}
+	if end >= len(data) {
+		return nil, 0
+	}
+
+	lineEnd := end + 1
+	for data[lineEnd] == ' ' {
+		lineEnd++
+	}
+	if data[lineEnd] != '\n' {
+		return nil, 0
+	}
+	return data[i+1 : end], lineEnd + 1
+}
+
+// Return the key that a reference's label is looked up by
+func (p *parser) referenceKey(label []byte) string {
+	if p.flags&EXTENSION_COMMONMARK == 0 {
+		return string(bytes.ToLower(label))
+	}
+
+	// labels match regardless of case and of how much whitespace they have
+	return string(bytes.ToLower(bytes.Join(bytes.Fields(label), []byte(" "))))
+}
+
+// Parse the whole document for link reference definitions before it is
+// parsed properly, since links can refer to definitions further down
+func (p *parser) commonMarkReferences(data []byte) {
+	var discard bytes.Buffer
+	p.skipInline = true
+	p.block(&discard, data)
+	p.skipInline = false
+}
+
+//
+// Block quotes and lists
+//
+
+// What the lines gathered into a block quote or list item end with, which
+// decides whether the line after them can carry on lazily, without the
+// prefix or indentation that the other lines have
+type commonMarkLines struct {
+	fence     []byte // the opening fence of a code block that is still open
+	paragraph bool   // whether the last line was part of a paragraph
+}
+
+// Take note of a line that has been added to lines
+func (p *parser) commonMarkTrack(lines *commonMarkLines, line []byte) {
+	switch {
+	case lines.fence != nil:
+		if p.isCommonMarkFenceEnd(line, lines.fence) {
+			lines.fence = nil
+		}
+		lines.paragraph = false
+	case p.commonMarkFence(line) != nil:
+		lines.fence = p.commonMarkFence(line)
+		lines.paragraph = false
+	case !lines.paragraph && p.codePrefix(line) > 0:
+		lines.paragraph = false
+	default:
+		lines.paragraph = p.isCommonMarkText(line)
+	}
+}
+
+// Check whether line is paragraph text, once the prefixes of any block
+// quotes and list items it starts are taken off
+func (p *parser) isCommonMarkText(line []byte) bool {
+	for {
+		if n := p.quotePrefix(line); n > 0 {
+			line = line[n:]
+		} else if n = p.commonMarkListMarker(line); n > 0 && !p.commonMarkEmptyItem(line, n) {
+			line = line[n:]
+		} else {
+			break
+		}
+	}
+	return p.isEmpty(line) == 0 && !p.isCommonMarkHeader(line) && !p.isHRule(line) &&
+		p.commonMarkFence(line) == nil && p.commonMarkHtmlStart(line) == 0
+}
+
+// Check whether line carries on the paragraph that lines end with
+func (p *parser) commonMarkLazy(lines *commonMarkLines, line []byte) bool {
+	return lines.paragraph && p.isEmpty(line) == 0 && !p.commonMarkInterrupts(line) &&
+		p.commonMarkListMarker(line) == 0
+}
+
+// parse a blockquote fragment
+func (p *parser) commonMarkQuote(out *bytes.Buffer, data []byte) int {
+	var raw bytes.Buffer
+	var offsets []int
+	var lines commonMarkLines
+	end := 0
+	for end < len(data) {
+		beg := end
+		eol := beg + bytes.IndexByte(data[beg:], '\n') + 1
+		if pre := p.quotePrefix(data[beg:]); pre > 0 {
+			beg += pre
+		} else if !p.commonMarkLazy(&lines, data[beg:]) {
+			break
+		}
+
+		// this line is part of the blockquote
+		p.commonMarkTrack(&lines, data[beg:eol])
+		raw.Write(data[beg:eol])
+		offsets = p.appendOffsets(offsets, data, beg, eol)
+		end = eol
+	}
+
+	var cooked bytes.Buffer
+	p.pushSource(raw.Bytes(), append(offsets, p.sourceOffset(data, end)))
+	p.block(&cooked, raw.Bytes())
+	p.popSource()
+	p.at(data, 0, end)
+	p.r.BlockQuote(out, cooked.Bytes())
+	return end
+}
+
+// Return the width of the list item marker that data starts with, including
+// the spaces after it that the item's contents are indented by, or 0 if
+// there isn't one
+func (p *parser) commonMarkListMarker(data []byte) int {
+	i := 0
+	for i < 3 && data[i] == ' ' {
+		i++
+	}
+	switch c := data[i]; {
+	case c == '-' || c == '+' || c == '*':
+		i++
+	case c >= '0' && c <= '9':
+		beg := i
+		for data[i] >= '0' && data[i] <= '9' {
+			i++
+		}
+		if i-beg > 9 || (data[i] != '.' && data[i] != ')') {
+			return 0
+		}
+		i++
+	default:
+		return 0
+	}
+	if data[i] != ' ' && data[i] != '\n' {
+		return 0
+	}
+
+	// the contents are indented by one to four spaces; any more and they
+	// start with indented code
+	n := 0
+	for data[i+n] == ' ' {
+		n++
+	}
+	if n > 4 || data[i+n] == '\n' {
+		n = 1
+	}
+	return i + n
+}
+
+// Return the character that tells which list the item that data starts with
+// belongs to ('-', '+' or '*' for bullets, '.' or ')' for numbers), and its
+// number
+func (p *parser) commonMarkListKind(data []byte) (byte, int) {
+	i := 0
+	for data[i] == ' ' {
+		i++
+	}
+	beg := i
+	for data[i] >= '0' && data[i] <= '9' {
+		i++
+	}
+	start, _ := strconv.Atoi(string(data[beg:i]))
+	return data[i], start
+}
+
+// Check whether the list item that data starts with has nothing on its
+// first line
+func (p *parser) commonMarkEmptyItem(data []byte, width int) bool {
+	eol := bytes.IndexByte(data, '\n')
+	return width > eol || p.isEmpty(data[width:]) > 0
+}
+
+// A list item, gathered up before it is parsed
+type commonMarkItem struct {
+	end     int    // where it ends in the list
+	raw     []byte // its contents, without their indentation
+	offsets []int  // the input offsets of raw
+}
+
+// parse ordered or unordered list block
+func (p *parser) commonMarkList(out *bytes.Buffer, data []byte) int {
+	kind, _ := p.commonMarkListKind(data)
+	flags := 0
+	if kind == '.' || kind == ')' {
+		flags |= LIST_TYPE_ORDERED
+	}
+
+	// gather the items, which share the kind of marker
+	var items []commonMarkItem
+	loose := false
+	i := 0
+	for i < len(data) {
+		item := p.commonMarkListItem(data[i:])
+		item.end += i
+		items = append(items, item)
+		i = item.end
+
+		// a blank line between items makes the list loose
+		next := i
+		for next < len(data) && p.isEmpty(data[next:]) > 0 {
+			next += p.isEmpty(data[next:])
+		}
+		if next >= len(data) || p.commonMarkListMarker(data[next:]) == 0 || p.isHRule(data[next:]) {
+			break
+		}
+		if k, _ := p.commonMarkListKind(data[next:]); k != kind {
+			break
+		}
+		loose = loose || next > i
+		i = next
+	}
+
+	// a blank line between the blocks of an item also makes the list loose,
+	// but that is only found by parsing the items. If it turns up, the
+	// items are parsed again; the answer is kept for next time, as lists
+	// are parsed once while looking for link reference definitions.
+	key := p.sourceOffset(data, 0)
+	if known, ok := p.looseLists[key]; ok {
+		loose = known
+	}
+	work := func() bool {
+		marker := out.Len()
+		if p.commonMarkItems(out, data, items, flags, loose) && !loose {
+			loose = true
+			out.Truncate(marker)
+			p.commonMarkItems(out, data, items, flags, loose)
+		}
+		p.looseLists[key] = loose
+		p.at(data, 0, i)
+		return true
+	}
+	p.r.List(out, work, flags)
+	return i
+}
+
+// Render the items of a list, and report whether any of them had a blank line
+// between its blocks
+func (p *parser) commonMarkItems(out *bytes.Buffer, data []byte, items []commonMarkItem, flags int, loose bool) bool {
+	tightNesting, looseNesting, wasLoose := p.tightNesting, p.looseNesting, p.loose
+	p.looseNesting, p.loose = p.nesting+1, false
+	p.tightNesting = 0
+	if !loose {
+		p.tightNesting = p.nesting + 1
+	}
+
+	flags |= LIST_ITEM_BEGINNING_OF_LIST
+	if loose {
+		flags |= LIST_ITEM_CONTAINS_BLOCK
+	}
+	beg := 0
+	for _, item := range items {
+		var cooked bytes.Buffer
+		if len(item.raw) > 0 {
+			p.pushSource(item.raw, item.offsets)
+			p.block(&cooked, item.raw)
+			p.popSource()
+		}
+
+		// strip trailing newlines
+		cookedBytes := cooked.Bytes()
+		for len(cookedBytes) > 0 && cookedBytes[len(cookedBytes)-1] == '\n' {
+			cookedBytes = cookedBytes[:len(cookedBytes)-1]
+		}
+		p.at(data, beg, item.end)
+		p.r.ListItem(out, cookedBytes, flags)
+		flags &^= LIST_ITEM_BEGINNING_OF_LIST
+		beg = item.end
+		for p.isEmpty(data[beg:]) > 0 {
+			beg += p.isEmpty(data[beg:])
+		}
+	}
+
+	blockGaps := p.loose
+	p.tightNesting, p.looseNesting, p.loose = tightNesting, looseNesting, wasLoose
+	return blockGaps
+}
+
+// Gather the lines of the list item that data starts with
+func (p *parser) commonMarkListItem(data []byte) commonMarkItem {
+	width := p.commonMarkListMarker(data)
+	var raw bytes.Buffer
+	var offsets []int
+	var lines commonMarkLines
+
+	// the first line, which can be empty
+	end := bytes.IndexByte(data, '\n') + 1
+	if !p.commonMarkEmptyItem(data, width) {
+		raw.Write(data[width:end])
+		offsets = p.appendOffsets(offsets, data, width, end)
+		p.commonMarkTrack(&lines, data[width:end])
+	}
+
+	for i := end; i < len(data); {
+		eol := i + bytes.IndexByte(data[i:], '\n') + 1
+		indent := 0
+		for data[i+indent] == ' ' {
+			indent++
+		}
+
+		switch {
+		case p.isEmpty(data[i:eol]) > 0:
+			// an item can start with at most one blank line
+			if raw.Len() == 0 {
+				return commonMarkItem{end: end}
+			}
+			i = eol
+			continue
+
+		case indent >= width:
+			// the blank lines before this one are part of the item too
+			for blank := end; blank < i; blank += p.isEmpty(data[blank:]) {
+				raw.WriteByte('\n')
+				offsets = append(offsets, p.sourceOffset(data, blank+p.isEmpty(data[blank:])-1))
+			}
+			raw.Write(data[i+width : eol])
+			offsets = p.appendOffsets(offsets, data, i+width, eol)
+			p.commonMarkTrack(&lines, data[i+width:eol])
+
+		case i == end && p.commonMarkLazy(&lines, data[i:]):
+			raw.Write(data[i:eol])
+			offsets = p.appendOffsets(offsets, data, i, eol)
+			p.commonMarkTrack(&lines, data[i:eol])
+
+		default:
+			return commonMarkItem{end, raw.Bytes(), append(offsets, p.sourceOffset(data, end))}
+		}
+		end, i = eol, eol
+	}
+	return commonMarkItem{end, raw.Bytes(), append(offsets, p.sourceOffset(data, end))}
+}
+
+//
+// Emphasis
+//
+
+// A run of '*' or '_' that might open or close emphasis. Emphasis is only
+// matched up once all the text around the runs has been parsed.
+type delimiter struct {
+	pos, posEnd       int // where the run was written to the output
+	beg, end          int // what is left of the run in the data
+	length            int // how long the run was to begin with
+	canOpen, canClose bool
+}
+
+// '*' and '_': note where a run of them is, to be matched up later
+func delimiterRun(p *parser, out *bytes.Buffer, data []byte, offset int) int {
+	c := data[offset]
+	end := offset
+	for end < len(data) && data[end] == c {
+		end++
+	}
+
+	// whether the run can open or close depends on what is either side of it
+	before, after := ' ', ' '
+	if offset > 0 {
+		before, _ = utf8.DecodeLastRune(data[:offset])
+	}
+	if end < len(data) {
+		after, _ = utf8.DecodeRune(data[end:])
+	}
+	spaceBefore, spaceAfter := unicode.IsSpace(before), unicode.IsSpace(after)
+	punctBefore, punctAfter := isCommonMarkPunct(before), isCommonMarkPunct(after)
+	left := !spaceAfter && (!punctAfter || spaceBefore || punctBefore)
+	right := !spaceBefore && (!punctBefore || spaceAfter || punctAfter)
+
+	d := delimiter{beg: offset, end: end, length: end - offset, canOpen: left, canClose: right}
+	if c == '_' {
+		// underscores don't make emphasis within words
+		d.canOpen = left && (!right || punctBefore)
+		d.canClose = right && (!left || punctAfter)
+	}
+
+	// write the run out as text, which is where it stays if nothing matches
+	d.pos = out.Len()
+	p.r.NormalText(out, data[offset:end])
+	d.posEnd = out.Len()
+	p.delimiters = append(p.delimiters, d)
+	return end - offset
+}
+
+func isCommonMarkPunct(r rune) bool {
+	if r < utf8.RuneSelf {
+		return ispunct(byte(r))
+	}
+	return unicode.IsPunct(r)
+}
+
+// A piece of the output of inline parsing: either a delimiter run, or
+// what was written between them
+type inlinePiece struct {
+	delim *delimiter
+	text  []byte
+}
+
+// Match up the delimiter runs that inline parsing of data found, the way
+// the spec's "process emphasis" procedure does, and rewrite the output
+// from the first of them on
+func (p *parser) matchDelimiters(out *bytes.Buffer, data []byte, delims []delimiter) {
+	// split the output up at the runs
+	tail := append([]byte(nil), out.Bytes()[delims[0].pos:]...)
+	out.Truncate(delims[0].pos)
+	var pieces []inlinePiece
+	for k := range delims {
+		d := &delims[k]
+		next := len(tail)
+		if k+1 < len(delims) {
+			next = delims[k+1].pos - delims[0].pos
+		}
+		pieces = append(pieces, inlinePiece{delim: d}, inlinePiece{text: tail[d.posEnd-delims[0].pos : next]})
+	}
+
+	for c := 0; c < len(pieces); c++ {
+		closer := pieces[c].delim
+		if closer == nil || !closer.canClose {
+			continue
+		}
+		for closer.end > closer.beg {
+			// look back for the closest opener of the same kind
+			o := c - 1
+			for ; o >= 0; o-- {
+				opener := pieces[o].delim
+				if opener != nil && opener.canOpen && opener.end > opener.beg &&
+					data[opener.beg] == data[closer.beg] && !delimitersRuleOfThree(opener, closer) {
+					break
+				}
+			}
+			if o < 0 {
+				break
+			}
+			opener := pieces[o].delim
+
+			// strong emphasis if both have two to spare
+			use := 1
+			if opener.end-opener.beg >= 2 && closer.end-closer.beg >= 2 {
+				use = 2
+			}
+
+			// the runs in between can't match anything any more
+			var content, node bytes.Buffer
+			for _, piece := range pieces[o+1 : c] {
+				p.writePiece(&content, data, piece)
+			}
+			opener.end -= use
+			closer.beg += use
+			p.at(data, opener.end, closer.beg)
+			if use == 2 {
+				p.r.DoubleEmphasis(&node, content.Bytes())
+			} else {
+				p.r.Emphasis(&node, content.Bytes())
+			}
+
+			pieces = append(pieces[:o+1], append([]inlinePiece{{text: node.Bytes()}}, pieces[c:]...)...)
+			c = o + 2
+		}
+	}
+
+	for _, piece := range pieces {
+		p.writePiece(out, data, piece)
+	}
+}
+
+// Runs that can both open and close can't match runs whose lengths add up to
+// a multiple of three, unless both are multiples of three
+func delimitersRuleOfThree(opener, closer *delimiter) bool {
+	return (opener.canClose || closer.canOpen) &&
+		(opener.length+closer.length)%3 == 0 &&
+		!(opener.length%3 == 0 && closer.length%3 == 0)
+}
+
+func (p *parser) writePiece(out *bytes.Buffer, data []byte, piece inlinePiece) {
+	if piece.delim != nil {
+		p.r.NormalText(out, data[piece.delim.beg:piece.delim.end])
+	} else {
+		out.Write(piece.text)
+	}
+}
@@ -0,0 +1,268 @@
+//
+// CommonMark tests
+//
+
+package blackfriday
+
+import (
+	"encoding/json"
+	"html"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+// An example from the CommonMark spec's spec.json
+type commonMarkExample struct {
+	Markdown string `json:"markdown"`
+	Html     string `json:"html"`
+	Example  int    `json:"example"`
+	Section  string `json:"section"`
+}
+
+// The version of the CommonMark spec kept in commonmarkref/spec.json
+const commonMarkVersion = "0.28"
+
+// Examples in the spec that EXTENSION_COMMONMARK doesn't handle yet, by
+// their number in the spec
+var commonMarkUnsupported = map[int]bool{
+	// Tabs
+	1: true, 2: true, 3: true,
+
+	// Setext headings
+	59: true, 60: true, 62: true,
+
+	// Indented code blocks
+	81: true,
+
+	// Fenced code blocks
+	90: true, 107: true, 111: true, 112: true, 113: true,
+
+	// Link reference definitions
+	167: true, 171: true,
+
+	// List items
+	228: true, 230: true, 231: true, 234: true, 259: true, 260: true,
+	262: true,
+
+	// Lists
+	265: true, 273: true, 274: true,
+
+	// Backslash escapes
+	289: true, 293: true, 297: true, 299: true, 301: true,
+
+	// Entity and numeric character references
+	302: true, 305: true, 307: true, 309: true, 310: true, 311: true,
+
+	// Code spans
+	316: true, 317: true, 320: true, 323: true, 327: true, 328: true,
+	330: true,
+
+	// Links
+	461: true, 462: true, 463: true, 464: true, 465: true, 466: true,
+	468: true, 470: true, 473: true, 474: true, 475: true, 476: true,
+	477: true, 478: true, 479: true, 482: true, 488: true, 489: true,
+	490: true, 491: true, 495: true, 496: true, 497: true, 502: true,
+	503: true, 504: true, 507: true, 508: true, 509: true, 513: true,
+	514: true, 521: true, 527: true, 538: true, 539: true,
+
+	// Images
+	544: true, 545: true, 546: true, 547: true, 548: true, 556: true,
+	558: true, 560: true, 564: true,
+
+	// Autolinks
+	573: true, 574: true, 577: true, 580: true, 581: true,
+
+	// Raw HTML
+	587: true, 589: true, 590: true, 591: true, 592: true, 593: true,
+	595: true, 596: true, 599: true, 600: true, 601: true, 604: true,
+
+	// Hard line breaks
+	606: true, 609: true, 611: true, 614: true, 615: true, 616: true,
+	618: true,
+}
+
+// The block tags of the spec's test runner, around which whitespace doesn't
+// matter
+var commonMarkBlockTags = map[string]bool{
+	"article": true, "header": true, "aside": true, "hgroup": true,
+	"blockquote": true, "hr": true, "iframe": true, "body": true, "li": true,
+	"map": true, "button": true, "object": true, "canvas": true, "ol": true,
+	"caption": true, "output": true, "col": true, "p": true,
+	"colgroup": true, "pre": true, "dd": true, "progress": true, "dir": true,
+	"section": true, "dl": true, "table": true, "td": true, "dt": true,
+	"tbody": true, "embed": true, "textarea": true, "fieldset": true,
+	"tfoot": true, "figcaption": true, "th": true, "figure": true,
+	"thead": true, "footer": true, "tr": true, "form": true, "ul": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"video": true, "script": true, "style": true,
+}
+
+var (
+	htmlChunk      = regexp.MustCompile(`(?s)<!\[CDATA\[.*?\]\]>|<[^>]*>|[^<]+`)
+	htmlStartTag   = regexp.MustCompile(`^<([a-zA-Z][^\t\n\r\f />\x00]*)((?s).*?)(/?)>$`)
+	htmlEndTag     = regexp.MustCompile(`^</([a-zA-Z][^\t\n\r\f />\x00]*)\s*>$`)
+	htmlAttribute  = regexp.MustCompile(`([^\s/>][^\s/=>]*)(\s*=\s*('[^']*'|"[^"]*"|[^'"\s>][^\s>]*))?`)
+	htmlWhitespace = regexp.MustCompile(`[\s\p{Z}\x{85}]+`)
+	htmlReference  = regexp.MustCompile(`&(#[0-9]+|#[xX][0-9a-fA-F]+|[a-zA-Z][-.a-zA-Z0-9]*);`)
+)
+
+// Put HTML in the form the spec's test runner (its normalize.py) compares
+// it in: entities are decoded, attributes sorted and quoted the same way,
+// self-closing tags lose their slash, whitespace outside <pre> is
+// collapsed, and whitespace next to block tags is dropped.
+func normalizeHtml(in string) string {
+	n := htmlNormalizer{last: "starttag"}
+	for _, chunk := range htmlChunk.FindAllString(in, -1) {
+		switch {
+		case strings.HasPrefix(chunk, "<![CDATA["),
+			strings.HasPrefix(chunk, "<!"), strings.HasPrefix(chunk, "<?"):
+			n.out += chunk
+			n.last = "decl"
+		case htmlEndTag.MatchString(chunk):
+			n.endTag(strings.ToLower(htmlEndTag.FindStringSubmatch(chunk)[1]))
+		case htmlStartTag.MatchString(chunk):
+			m := htmlStartTag.FindStringSubmatch(chunk)
+			tag := strings.ToLower(m[1])
+			n.startTag(tag, m[2])
+			if m[3] != "" {
+				n.last = "endtag"
+			}
+		default:
+			n.text(chunk)
+		}
+	}
+	return n.out
+}
+
+// The state of normalizeHtml, after the parser in the spec's normalize.py
+type htmlNormalizer struct {
+	out     string
+	last    string // the kind of the last thing seen
+	lastTag string
+	inPre   bool
+}
+
+func (n *htmlNormalizer) startTag(tag, attributes string) {
+	if tag == "pre" {
+		n.inPre = true
+	}
+	if commonMarkBlockTags[tag] {
+		n.out = strings.TrimRightFunc(n.out, unicode.IsSpace)
+	}
+
+	var attrs []string
+	for _, m := range htmlAttribute.FindAllStringSubmatch(attributes, -1) {
+		attr := " " + strings.ToLower(m[1])
+		if m[2] != "" {
+			value := m[3]
+			if value[0] == '\'' || value[0] == '"' {
+				value = value[1 : len(value)-1]
+			}
+			value = strings.Replace(html.EscapeString(html.UnescapeString(value)), "&#34;", "&quot;", -1)
+			attr += `="` + strings.Replace(value, "&#39;", "&#x27;", -1) + `"`
+		}
+		attrs = append(attrs, attr)
+	}
+	sort.Strings(attrs)
+
+	n.out += "<" + tag + strings.Join(attrs, "") + ">"
+	n.lastTag = tag
+	n.last = "starttag"
+}
+
+func (n *htmlNormalizer) endTag(tag string) {
+	if tag == "pre" {
+		n.inPre = false
+	} else if commonMarkBlockTags[tag] {
+		n.out = strings.TrimRightFunc(n.out, unicode.IsSpace)
+	}
+	n.out += "</" + tag + ">"
+	n.lastTag = tag
+	n.last = "endtag"
+}
+
+// Text, in which each entity and character reference is decoded on its own
+func (n *htmlNormalizer) text(text string) {
+	for text != "" {
+		loc := htmlReference.FindStringIndex(text)
+		if loc == nil {
+			n.data(text)
+			return
+		}
+		if loc[0] > 0 {
+			n.data(text[:loc[0]])
+		}
+		n.reference(text[loc[0]:loc[1]])
+		text = text[loc[1]:]
+	}
+}
+
+func (n *htmlNormalizer) data(data string) {
+	afterTag := n.last == "endtag" || n.last == "starttag"
+	afterBlockTag := afterTag && commonMarkBlockTags[n.lastTag]
+	if afterTag && n.lastTag == "br" {
+		data = strings.TrimLeft(data, "\n")
+	}
+	if !n.inPre {
+		data = htmlWhitespace.ReplaceAllString(data, " ")
+	}
+	if afterBlockTag && !n.inPre {
+		if n.last == "starttag" {
+			data = strings.TrimLeftFunc(data, unicode.IsSpace)
+		} else {
+			data = strings.TrimSpace(data)
+		}
+	}
+	n.out += data
+	n.last = "data"
+}
+
+func (n *htmlNormalizer) reference(ref string) {
+	switch c := html.UnescapeString(ref); {
+	case c == ref:
+		n.out += ref
+	case c == "<":
+		n.out += "&lt;"
+	case c == ">":
+		n.out += "&gt;"
+	case c == "&":
+		n.out += "&amp;"
+	case c == `"`:
+		n.out += "&quot;"
+	default:
+		n.out += c
+	}
+	n.last = "ref"
+}
+
+func TestCommonMark(t *testing.T) {
+	filename := filepath.Join("commonmarkref", "spec.json")
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Couldn't open '%s', error: %v\n", filename, err)
+	}
+	var examples []commonMarkExample
+	if err := json.Unmarshal(data, &examples); err != nil {
+		t.Fatalf("Couldn't parse '%s', error: %v\n", filename, err)
+	}
+
+	for _, example := range examples {
+		renderer := HtmlRenderer(0, "", "")
+		actual := string(Markdown([]byte(example.Markdown), renderer, EXTENSION_COMMONMARK))
+		passed := normalizeHtml(actual) == normalizeHtml(example.Html)
+
+		switch {
+		case !passed && !commonMarkUnsupported[example.Example]:
+			t.Errorf("\nExample %d (%s)\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]",
+				example.Example, example.Section, example.Markdown, example.Html, actual)
+		case passed && commonMarkUnsupported[example.Example]:
+			t.Errorf("Example %d (%s) passes, but is listed as unsupported",
+				example.Example, example.Section)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package flower
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// The path that agents answer check requests on
+const AGENT_PATH = "/check"
+
+// How much longer than a check can take an AgentExecutor waits for the agent
+// to answer, to allow for sending the request and running the directive
+const AGENT_SLACK = 5 * time.Second
+
+// The longest timeout an agent accepts for each attempt of a check, whether
+// it comes with the request or is in the directive
+const AGENT_MAX_TIMEOUT = time.Minute
+
+// Executor runs checks from another machine, for directives whose caller is
+// not this one
+type Executor interface {
+	// Run a directive from the remote machine, returning its result. Aliases
+	// are those defined in the document before the directive. The directive
+	// is as written; variables holds the values of those it refers to
+	Check(directive string, aliases map[string]string, variables map[string]string, settings *Settings) (Result, error)
+}
+
+// Implemented by commands that can take the result of a check run elsewhere
+type remoteCommand interface {
+	applyResult(result Result, via string)
+	checkSettings(settings *Settings) (time.Duration, int)
+}
+
+// The form of a check request sent to an agent
+type agentRequest struct {
+	Directive string            `json:"directive"`
+	Aliases   map[string]string `json:"aliases,omitempty"`
+	Variables map[string]string `json:"variables,omitempty"`
+	Timeout   string            `json:"timeout,omitempty"`
+	Retries   int               `json:"retries"`
+}
+
+// AgentExecutor is an Executor that sends checks to a flower Agent over
+// HTTP, as JSON
+type AgentExecutor struct {
+	// The agent's address, eg: http://web1:7070
+	URL string
+
+	// The client used to reach the agent
+	Client *http.Client
+
+	// How much longer than the check can take to wait for the agent's
+	// answer, before giving up on it
+	Slack time.Duration
+
+	// Sent to the agent as a bearer token, when not empty. See Agent.SetToken
+	Token string
+}
+
+// Create an AgentExecutor for the agent at url
+func NewAgentExecutor(url string) *AgentExecutor {
+	return &AgentExecutor{URL: url, Client: http.DefaultClient, Slack: AGENT_SLACK}
+}
+
+// Return how long a check run with the settings can take: the timeout for
+// the first attempt and each retry
+func checkDuration(settings *Settings) time.Duration {
+	timeout := settings.Timeout
+	if timeout <= 0 {
+		timeout = DEFAULT_TIMEOUT
+	}
+	retries := settings.Retries
+	if retries < 0 {
+		retries = 0
+	}
+	if retries > MAX_RETRIES {
+		retries = MAX_RETRIES
+	}
+	return timeout * time.Duration(retries+1)
+}
+
+func (executor *AgentExecutor) Check(directive string, aliases map[string]string, variables map[string]string, settings *Settings) (Result, error) {
+	request := agentRequest{Directive: directive, Aliases: aliases, Variables: variables, Retries: settings.Retries}
+	if settings.Timeout > 0 {
+		request.Timeout = settings.Timeout.String()
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// an agent that never answers must not hold up the run
+	ctx, cancel := context.WithTimeout(context.Background(), checkDuration(settings)+executor.Slack)
+	defer cancel()
+	post, err := http.NewRequest("POST", executor.URL+AGENT_PATH, bytes.NewReader(body))
+	if err != nil {
+		return Result{}, err
+	}
+	post.Header.Set("Content-Type", "application/json")
+	if executor.Token != "" {
+		post.Header.Set("Authorization", "Bearer "+executor.Token)
+	}
+	response, err := executor.Client.Do(post.WithContext(ctx))
+	if err != nil {
+		return Result{}, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return Result{}, fmt.Errorf("agent %s answered %s", executor.URL, response.Status)
+	}
+
+	var object jsonResult
+	if err := json.NewDecoder(response.Body).Decode(&object); err != nil {
+		return Result{}, fmt.Errorf("agent %s: %v", executor.URL, err)
+	}
+	return object.result(), nil
+}
+
+// Agent answers check requests from an AgentExecutor, by running each
+// directive on this machine. Serve it with net/http, eg:
+// http.ListenAndServe("127.0.0.1:7070", flower.NewAgent())
+//
+// An agent runs whatever check it is sent, so anyone who can reach it can
+// probe the network from this machine. Serve it only where the executors can
+// reach it, and set a token when that is beyond this machine.
+type Agent struct {
+	// The directives the agent understands
+	registry *Registry
+
+	// The bearer token requests must carry, or "" to accept any request
+	token string
+
+	// Passed to each command; the timeout and retries are taken from the
+	// request
+	settings Settings
+}
+
+// Create an Agent that understands the built in commands, and uses the real
+// network
+func NewAgent() *Agent {
+	return &Agent{
+		registry: NewDefaultRegistry(),
+		settings: Settings{Prober: NetProber{}},
+	}
+}
+
+// Return the directives the agent understands
+func (agent *Agent) Registry() *Registry {
+	return agent.registry
+}
+
+// Set how the agent reaches the network
+func (agent *Agent) SetProber(prober Prober) {
+	agent.settings.Prober = prober
+}
+
+// Set the token that requests must carry, in an Authorization: Bearer header.
+// Executors send it when their Token is set. An empty token turns the check
+// off
+func (agent *Agent) SetToken(token string) {
+	agent.token = token
+}
+
+// Return true if the request carries the agent's token, or none is needed
+func (agent *Agent) authorized(r *http.Request) bool {
+	if agent.token == "" {
+		return true
+	}
+	expected := "Bearer " + agent.token
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expected)) == 1
+}
+
+func (agent *Agent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != AGENT_PATH {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if !agent.authorized(r) {
+		http.Error(w, "missing or wrong token", http.StatusUnauthorized)
+		return
+	}
+
+	var request agentRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	settings := agent.settings
+	settings.Timeout = DEFAULT_TIMEOUT
+	settings.Retries = request.Retries
+	if request.Timeout != "" {
+		timeout, err := time.ParseDuration(request.Timeout)
+		if err != nil || timeout <= 0 || timeout > AGENT_MAX_TIMEOUT {
+			http.Error(w, "invalid timeout "+request.Timeout, http.StatusBadRequest)
+			return
+		}
+		settings.Timeout = timeout
+	}
+
+	command := agent.command(request)
+	if err := agent.allowed(command, &settings); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newJSONResult(agent.check(request, command, &settings)))
+}
+
+// Build the command for a request's directive, or return nil if it is not a
+// directive. Its variables are substituted from the request alone, never
+// from the agent's environment
+func (agent *Agent) command(request agentRequest) Command {
+	lookup := func(name string) (string, bool) {
+		value, ok := request.Variables[name]
+		return value, ok
+	}
+	substituted, _, diagnostic := substituteVariables(request.Directive, lookup)
+	var command Command
+	if diagnostic == nil {
+		command, diagnostic = agent.registry.Parse(substituted)
+	}
+	if diagnostic != nil {
+		command = NewSyntaxErrorCommand(*diagnostic)
+	}
+	return command
+}
+
+// Return why the agent won't run a command for a remote caller, or nil if it
+// will. Checks may not take longer than AGENT_MAX_TIMEOUT for each attempt,
+// and may not read files on this machine
+func (agent *Agent) allowed(command Command, settings *Settings) error {
+	if cmd, ok := command.(remoteCommand); ok {
+		if timeout, _ := cmd.checkSettings(settings); timeout > AGENT_MAX_TIMEOUT {
+			return fmt.Errorf("timeout %s is longer than %s", timeout, AGENT_MAX_TIMEOUT)
+		}
+	}
+	if cmd, ok := command.(*TlsCommand); ok && cmd.caFile != "" {
+		return fmt.Errorf("ca files are not read for remote checks")
+	}
+	return nil
+}
+
+// Run a request's command, returning its result
+func (agent *Agent) check(request agentRequest, command Command, settings *Settings) Result {
+	if command == nil {
+		return Result{Directive: request.Directive, Result: ERROR, Err: fmt.Errorf("not a flower directive")}
+	}
+	if resolver, ok := command.(aliasResolver); ok {
+		resolver.resolveAliases(request.Aliases)
+	}
+
+	start := time.Now()
+	command.Execute(settings)
+	result := command.Report()
+	result.Directive = request.Directive
+	result.Duration = time.Since(start)
+	return result
+}
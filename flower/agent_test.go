@@ -0,0 +1,208 @@
+package flower
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Return a server running an agent on web1, which can reach postgres on db
+func testAgent() *httptest.Server {
+	prober := NewScriptedProber()
+	prober.AddLocal("10.0.0.1")
+	prober.AddHost("web1", "10.0.0.1")
+	prober.Open("tcp", "10.0.0.2:5432")
+
+	agent := NewAgent()
+	agent.SetProber(prober)
+	return httptest.NewServer(agent)
+}
+
+func Test_AgentExecutor(t *testing.T) {
+	server := testAgent()
+	defer server.Close()
+
+	// the bastion is none of the hosts, and cannot resolve web1
+	bastion := NewScriptedProber()
+	bastion.AddLocal("10.0.0.9")
+
+	interpreter := NewInterpreter()
+	interpreter.SetProber(bastion)
+	interpreter.SetExecutor("web1", NewAgentExecutor(server.URL))
+	interpreter.EvaluateCode("flower: 10.0.0.2 is db")
+	ok := interpreter.EvaluateCode("flower: web1 uses postgres at db")
+	closed := interpreter.EvaluateCode("flower: web1 uses mysql at db")
+	other := interpreter.EvaluateCode("flower: web2 uses postgres at db")
+	interpreter.Run()
+
+	tests := []struct {
+		cmd    Command
+		match  int
+		result int
+	}{
+		{ok, MATCH_CALLER, OK},
+		{closed, MATCH_CALLER, FAIL},
+		{other, NO_MATCH, ERROR},
+	}
+	for _, test := range tests {
+		result := test.cmd.Report()
+		if result.Match != test.match || result.Result != test.result {
+			t.Errorf("Expected %s %s, got %s", MatchName(test.match), ResultName(test.result), test.cmd)
+		}
+	}
+	if !strings.Contains(ok.String(), ", via:web1") || strings.Contains(other.String(), "via:") {
+		t.Errorf("Expected only remote checks to say how they ran, got %s and %s", ok, other)
+	}
+}
+
+func Test_AgentExecutor_Variables(t *testing.T) {
+	server := testAgent()
+	defer server.Close()
+
+	bastion := NewScriptedProber()
+	bastion.AddLocal("10.0.0.9")
+
+	interpreter := NewInterpreter(map[string]string{"db": "10.0.0.2"})
+	interpreter.SetProber(bastion)
+	interpreter.SetExecutor("web1", NewAgentExecutor(server.URL))
+	cmd := interpreter.EvaluateCode("flower: web1 uses postgres at ${db}")
+	interpreter.Run()
+
+	if result := cmd.Report(); result.Match != MATCH_CALLER || result.Result != OK {
+		t.Errorf("Expected the agent to substitute the variable, got %s", cmd)
+	}
+	if directive := interpreter.Results()[0].Directive; directive != "flower: web1 uses postgres at ${db}" {
+		t.Errorf("Expected the directive as written, got %s", directive)
+	}
+
+	// the agent substitutes only the values it is sent
+	executor := NewAgentExecutor(server.URL)
+	result, err := executor.Check("flower: web1 uses postgres at ${PATH}", nil, nil, &Settings{})
+	if err != nil || result.Result != ERROR || !strings.Contains(result.Err.Error(), "undefined variable PATH") {
+		t.Errorf("Expected an undefined variable, got %+v, %v", result, err)
+	}
+}
+
+func Test_AgentExecutor_Errors(t *testing.T) {
+	server := testAgent()
+	server.Close()
+
+	interpreter := NewInterpreter()
+	interpreter.SetProber(testProber())
+	interpreter.SetExecutor("web1", NewAgentExecutor(server.URL))
+	cmd := interpreter.EvaluateCode("flower: web1 uses postgres at db")
+	interpreter.Run()
+	if result := cmd.Report(); result.Result != ERROR || result.Err == nil {
+		t.Errorf("Expected an error reaching the agent, got %s", cmd)
+	}
+}
+
+func Test_Agent(t *testing.T) {
+	server := testAgent()
+	defer server.Close()
+
+	response, err := http.Get(server.URL + AGENT_PATH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected GET to be refused, got %s", response.Status)
+	}
+
+	response, err = http.Post(server.URL+AGENT_PATH, "application/json", strings.NewReader("{"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a bad request, got %s", response.Status)
+	}
+
+	executor := NewAgentExecutor(server.URL)
+	result, err := executor.Check("flower: web1 uses postgres:99999 at db", nil, nil, &Settings{})
+	if err != nil || result.Result != ERROR || result.Err == nil || !strings.Contains(result.Reason, "port out of range") {
+		t.Errorf("Expected a syntax error, got %+v %v", result, err)
+	}
+	result, err = executor.Check("flower: web1 uses postgres at 10.0.0.2 timeout 1s", nil, nil, &Settings{Timeout: DEFAULT_TIMEOUT})
+	if err != nil || result.Result != OK || result.Match != MATCH_CALLER || result.Port != 5432 {
+		t.Errorf("Expected OK, got %+v %v", result, err)
+	}
+}
+
+func Test_Agent_Limits(t *testing.T) {
+	server := testAgent()
+	defer server.Close()
+
+	response, err := http.Post(server.URL+AGENT_PATH, "application/json",
+		strings.NewReader(`{"directive":"flower: web1 uses postgres at 10.0.0.2","timeout":"10000h"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a timeout that is too long to be refused, got %s", response.Status)
+	}
+
+	executor := NewAgentExecutor(server.URL)
+	for _, directive := range []string{
+		"flower: web1 uses postgres at 10.0.0.2 timeout 10000h",
+		"flower: web1 offers https cert-cn web1 ca /etc/shadow",
+	} {
+		if _, err := executor.Check(directive, nil, nil, &Settings{}); err == nil || !strings.Contains(err.Error(), "400") {
+			t.Errorf("Expected %s to be refused, got %v", directive, err)
+		}
+	}
+}
+
+func Test_AgentExecutor_Hangs(t *testing.T) {
+	done := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	executor := NewAgentExecutor(server.URL)
+	executor.Slack = 50 * time.Millisecond
+	interpreter := NewInterpreter()
+	interpreter.SetProber(testProber())
+	interpreter.SetExecutor("web1", executor)
+	cmd := interpreter.EvaluateCode("flower: web1 uses postgres at db timeout 20ms retries 1")
+
+	start := time.Now()
+	interpreter.Run()
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected to give up on the agent, waited %s", elapsed)
+	}
+	if result := cmd.Report(); result.Result != ERROR || result.Err == nil || !strings.Contains(result.Err.Error(), "deadline") {
+		t.Errorf("Expected the agent to time out, got %s", cmd)
+	}
+}
+
+func Test_Agent_Token(t *testing.T) {
+	prober := NewScriptedProber()
+	prober.AddLocal("10.0.0.1")
+	prober.Open("tcp", "10.0.0.2:5432")
+	agent := NewAgent()
+	agent.SetProber(prober)
+	agent.SetToken("secret")
+	server := httptest.NewServer(agent)
+	defer server.Close()
+
+	directive := "flower: 10.0.0.1 uses postgres at 10.0.0.2"
+	executor := NewAgentExecutor(server.URL)
+	if _, err := executor.Check(directive, nil, nil, &Settings{}); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Expected a request without the token to be refused, got %v", err)
+	}
+	executor.Token = "wrong"
+	if _, err := executor.Check(directive, nil, nil, &Settings{}); err == nil {
+		t.Errorf("Expected a request with the wrong token to be refused")
+	}
+	executor.Token = "secret"
+	if result, err := executor.Check(directive, nil, nil, &Settings{}); err != nil || result.Result != OK {
+		t.Errorf("Expected OK with the token, got %+v %v", result, err)
+	}
+}
@@ -0,0 +1,93 @@
+package flower
+
+import (
+	"net"
+	"strings"
+)
+
+// AliasCommand gives a name to an IP address, or to a range of addresses
+// written as a pattern such as 10.0.*
+type AliasCommand struct {
+	// The IP address or pattern
+	ip string
+
+	// The name given to it
+	alias string
+
+	// If the alias conflicts with an earlier one store the error here
+	err error
+}
+
+func NewAliasCommand(ip string, alias string) *AliasCommand {
+	cmd := new(AliasCommand)
+	cmd.ip = ip
+	cmd.alias = alias
+	return cmd
+}
+
+// Aliases are recorded by the interpreter as they are seen, so there is
+// nothing to do when executed
+func (cmd *AliasCommand) Execute(settings *Settings) {
+}
+
+func (cmd *AliasCommand) HtmlClass() string {
+	class := "FLOWER-ALIAS"
+	if cmd.err != nil {
+		class += " FLOWER-ERROR"
+	}
+	return class
+}
+
+func (cmd *AliasCommand) String() string {
+	str := "alias:" + cmd.alias + ", ip:" + cmd.ip
+	if cmd.err != nil {
+		str += ", error:"
+		str += cmd.err.Error()
+	}
+	return str
+}
+
+// An alias has no service to check, so its result is OK once it has been
+// defined, or ERROR if it conflicts with an earlier alias
+func (cmd *AliasCommand) Report() Result {
+	result := Result{
+		Host:    cmd.alias,
+		Service: "alias " + cmd.ip,
+		Match:   NO_MATCH,
+		Result:  OK,
+		Err:     cmd.err,
+	}
+	if cmd.err != nil {
+		result.Result = ERROR
+	}
+	return result
+}
+
+// Return true if an address contains wildcards, and so stands for a range of
+// addresses rather than a single host
+func IsAddressPattern(address string) bool {
+	return strings.Contains(address, "*")
+}
+
+// Return true if ip matches an address pattern. Each '*' in the pattern
+// matches one part of the address, and a trailing '*' matches all of the
+// remaining parts, so 10.0.* matches 10.0.3.4
+func MatchAddressPattern(pattern string, ip net.IP) bool {
+	patternParts := strings.Split(pattern, ".")
+	ipParts := strings.Split(ip.String(), ".")
+	for i, part := range patternParts {
+		if i >= len(ipParts) {
+			return false
+		}
+		if part == "*" {
+			if i == len(patternParts)-1 {
+				return true
+			}
+			continue
+		}
+		if part != ipParts[i] {
+			return false
+		}
+	}
+	return len(patternParts) == len(ipParts)
+}
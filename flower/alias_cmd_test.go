@@ -0,0 +1,96 @@
+package flower
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func Test_MatchAddressPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		ip      string
+		match   bool
+	}{
+		{"10.0.*", "10.0.3.4", true},
+		{"10.0.*", "10.1.3.4", false},
+		{"10.*.3.4", "10.9.3.4", true},
+		{"10.*.3.4", "10.9.3.5", false},
+		{"10.0.3.*", "10.0.3.4", true},
+		{"10.0.3.4", "10.0.3.4", true},
+		{"10.0.3", "10.0.3.4", false},
+		{"*", "192.168.1.1", true},
+	}
+	for _, test := range tests {
+		if MatchAddressPattern(test.pattern, net.ParseIP(test.ip)) != test.match {
+			t.Errorf("MatchAddressPattern('%s', %s) should return %t", test.pattern, test.ip, test.match)
+		}
+	}
+}
+
+func Test_AliasResolution(t *testing.T) {
+	prober := testProber()
+	prober.Open("tcp", "10.0.0.2:5432")
+	interpreter := NewInterpreter()
+	interpreter.SetProber(prober)
+
+	before := interpreter.EvaluateCode("flower: db1 offers smtp").(*ServiceCommand)
+	interpreter.EvaluateCode("flower: 10.0.0.2 is db1")
+	after := interpreter.EvaluateCode("flower: db1 offers http:5432").(*ServiceCommand)
+	caller := interpreter.EvaluateCode("flower: db1 uses http:5432 at www.gogle.com").(*ServiceCommand)
+	interpreter.Run()
+
+	if before.hostAddress != "" || before.err == nil {
+		t.Errorf("Alias should not apply to earlier directives, got %s", before)
+	}
+	if after.hostAddress != "10.0.0.2" || after.match != MATCH_HOST || after.result != OK {
+		t.Errorf("Expected db1 to resolve to 10.0.0.2 and pass, got %s", after)
+	}
+	if caller.callerAddress != "10.0.0.2" || caller.match != MATCH_CALLER {
+		t.Errorf("Expected caller db1 to resolve to 10.0.0.2, got %s", caller)
+	}
+}
+
+func Test_AliasPattern(t *testing.T) {
+	prober := testProber()
+	prober.Open("tcp", "10.0.0.2:80")
+	interpreter := NewInterpreter()
+	interpreter.SetProber(prober)
+
+	interpreter.EvaluateCode("flower: 10.0.* is web")
+	interpreter.EvaluateCode("flower: 192.168.* is remote")
+	offers := interpreter.EvaluateCode("flower: web offers http").(*ServiceCommand)
+	uses := interpreter.EvaluateCode("flower: web uses http at remote").(*ServiceCommand)
+	other := interpreter.EvaluateCode("flower: remote offers http").(*ServiceCommand)
+	interpreter.Run()
+
+	if offers.match != MATCH_HOST || offers.result != OK {
+		t.Errorf("Expected web to match this machine and pass, got %s", offers)
+	}
+	if uses.match != MATCH_CALLER || uses.err == nil {
+		t.Errorf("Expected an error checking a range of addresses, got %s", uses)
+	}
+	if other.match != NO_MATCH || other.err != nil {
+		t.Errorf("Expected remote not to match this machine, got %s", other)
+	}
+}
+
+func Test_AliasConflict(t *testing.T) {
+	interpreter := NewInterpreter()
+	first := interpreter.EvaluateCode("flower: 10.0.0.5 is db1").(*AliasCommand)
+	same := interpreter.EvaluateCode("flower: 10.0.0.5 is db1").(*AliasCommand)
+	conflict := interpreter.EvaluateCode("flower: 10.0.0.6 is db1").(*AliasCommand)
+
+	if first.err != nil || same.err != nil {
+		t.Errorf("Repeating an alias should not be an error, got %s and %s", first, same)
+	}
+	if conflict.err == nil {
+		t.Errorf("Expected an error redefining an alias, got %s", conflict)
+	}
+	if interpreter.aliases["db1"] != "10.0.0.5" {
+		t.Errorf("Expected the first definition to be kept, got %s", interpreter.aliases["db1"])
+	}
+	if !strings.Contains(string(interpreter.SummaryReport()), conflict.String()) {
+		t.Errorf("Expected the conflict in the summary, got %s", interpreter.SummaryReport())
+	}
+}
@@ -0,0 +1,240 @@
+package flower
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Catalogue maps service names to their default ports, and to the protocol
+// used to check them when it is not tcp. A Catalogue is safe to use from many
+// goroutines
+type Catalogue struct {
+	lock      sync.RWMutex
+	ports     map[string]int
+	protocols map[string]string
+}
+
+// Create a Catalogue that knows no services
+func NewCatalogue() *Catalogue {
+	return &Catalogue{
+		ports:     make(map[string]int),
+		protocols: make(map[string]string),
+	}
+}
+
+// Create a Catalogue of the well known services in ServicePort and
+// ServiceProtocol
+func NewDefaultCatalogue() *Catalogue {
+	catalogue := NewCatalogue()
+	for service, port := range ServicePort {
+		catalogue.ports[service] = port
+	}
+	for service, protocol := range ServiceProtocol {
+		catalogue.protocols[service] = protocol
+	}
+	return catalogue
+}
+
+// Add a service, or change its port. The protocol is one of those in
+// protocolProbes, or "" to leave the protocol unchanged
+func (catalogue *Catalogue) Add(service string, port int, protocol string) error {
+	if port < 1 || port > MAX_PORT {
+		return fmt.Errorf("port %d out of range for %s", port, service)
+	}
+	if _, ok := protocolProbes[protocol]; protocol != "" && !ok {
+		return fmt.Errorf("unknown protocol %s for %s", protocol, service)
+	}
+	catalogue.lock.Lock()
+	defer catalogue.lock.Unlock()
+	catalogue.ports[service] = port
+	if protocol != "" {
+		catalogue.protocols[service] = protocol
+	}
+	return nil
+}
+
+// Return the default port of a service, and whether it is known
+func (catalogue *Catalogue) Port(service string) (int, bool) {
+	catalogue.lock.RLock()
+	defer catalogue.lock.RUnlock()
+	port, ok := catalogue.ports[service]
+	return port, ok
+}
+
+// Return the protocol used to check a service
+func (catalogue *Catalogue) Protocol(service string) string {
+	catalogue.lock.RLock()
+	defer catalogue.lock.RUnlock()
+	if protocol, ok := catalogue.protocols[service]; ok {
+		return protocol
+	}
+	return "tcp"
+}
+
+// Add the services in a file. Files ending .json are read with LoadJSON,
+// .yaml or .yml with LoadYAML, and anything else with LoadServices
+func (catalogue *Catalogue) LoadFile(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		err = catalogue.LoadJSON(file)
+	case ".yaml", ".yml":
+		err = catalogue.LoadYAML(file)
+	default:
+		err = catalogue.LoadServices(file)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	return nil
+}
+
+// Add the services in the format of /etc/services, where each line gives a
+// name, a port and protocol, and any aliases, eg: 'postgresql 5432/tcp postgres'.
+// A service that is only listed for udp is checked with udp
+func (catalogue *Catalogue) LoadServices(r io.Reader) error {
+	type listing struct {
+		port int
+		tcp  bool
+	}
+	var names []string
+	listings := make(map[string]*listing)
+
+	scanner := bufio.NewScanner(r)
+	for number := 1; scanner.Scan(); number++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return fmt.Errorf("line %d: missing port", number)
+		}
+		port, protocol, err := parsePortProtocol(fields[1])
+		if err != nil {
+			return fmt.Errorf("line %d: %v", number, err)
+		}
+		if protocol != "tcp" && protocol != "udp" {
+			// eg: sctp, which cannot be checked
+			continue
+		}
+		for _, name := range append(fields[:1], fields[2:]...) {
+			entry, ok := listings[name]
+			if !ok {
+				entry = &listing{port: port}
+				listings[name] = entry
+				names = append(names, name)
+			}
+			if protocol == "tcp" {
+				entry.port = port
+				entry.tcp = true
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		protocol := "udp"
+		if listings[name].tcp {
+			protocol = ""
+		}
+		if err := catalogue.Add(name, listings[name].port, protocol); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add the services in a JSON object, mapping each name to a port, or to a
+// port and protocol such as "514/udp", eg: {"postgres": 5432, "syslog": "514/udp"}
+func (catalogue *Catalogue) LoadJSON(r io.Reader) error {
+	var services map[string]interface{}
+	if err := json.NewDecoder(r).Decode(&services); err != nil {
+		return err
+	}
+	for name, value := range services {
+		var err error
+		switch value := value.(type) {
+		case float64:
+			err = catalogue.Add(name, int(value), "")
+		case string:
+			err = catalogue.addPortProtocol(name, value)
+		default:
+			err = fmt.Errorf("invalid port for %s", name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add the services in a YAML mapping, with one 'name: port' on each line,
+// where the port may be followed by a protocol such as 514/udp. Only this
+// simple form of YAML is understood
+func (catalogue *Catalogue) LoadYAML(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for number := 1; scanner.Scan(); number++ {
+		line := scanner.Text()
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || line == "---" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 {
+			return fmt.Errorf("line %d: expected name: port", number)
+		}
+		name := strings.Trim(strings.TrimSpace(line[:colon]), `"'`)
+		value := strings.Trim(strings.TrimSpace(line[colon+1:]), `"'`)
+		if err := catalogue.addPortProtocol(name, value); err != nil {
+			return fmt.Errorf("line %d: %v", number, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// Add a service given a port, optionally followed by a protocol, eg: 514/udp
+func (catalogue *Catalogue) addPortProtocol(service string, value string) error {
+	port, protocol, err := parsePortProtocol(value)
+	if err != nil {
+		return fmt.Errorf("%v for %s", err, service)
+	}
+	if protocol == "tcp" && !strings.Contains(value, "/") {
+		protocol = ""
+	}
+	return catalogue.Add(service, port, protocol)
+}
+
+// Parse a port, optionally followed by a protocol, eg: 514/udp. The protocol
+// is tcp when not given
+func parsePortProtocol(value string) (int, string, error) {
+	protocol := "tcp"
+	if i := strings.IndexByte(value, '/'); i >= 0 {
+		value, protocol = value[:i], strings.ToLower(value[i+1:])
+	}
+	port, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid port %q", value)
+	}
+	return port, protocol, nil
+}
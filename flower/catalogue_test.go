@@ -0,0 +1,127 @@
+package flower
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func Test_DefaultCatalogue(t *testing.T) {
+	tests := map[string]string{
+		"flower: db offers postgres":      "postgres 5432 tcp",
+		"flower: web uses redis at cache": "redis 6379 tcp",
+		"flower: ns1 offers dns":          "dns 53 dns",
+		"flower: ntp1 offers ntp":         "ntp 123 udp",
+		"flower: web uses udp:514 at log": "udp 514 udp",
+	}
+	for input, expected := range tests {
+		cmd, ok := Parse(input).(*ServiceCommand)
+		if !ok {
+			t.Errorf("Input %s, expected a service command", input)
+			continue
+		}
+		actual := cmd.service + " " + strconv.Itoa(cmd.port) + " " + cmd.protocol
+		if actual != expected {
+			t.Errorf("Input %s, expected %s, got %s", input, expected, actual)
+		}
+	}
+
+	_, diagnostic := ParseDirective("flower: db offers cassandra")
+	if diagnostic == nil || diagnostic.Reason != "unknown service with no port" {
+		t.Errorf("Expected unknown service, got %v", diagnostic)
+	}
+}
+
+func Test_Catalogue_LoadServices(t *testing.T) {
+	services := `
+# comment
+postgresql	5432/tcp	postgres	# PostgreSQL Database
+cassandra	9042/tcp
+rtp		5004/udp
+snmp		161/tcp
+snmp		161/udp
+sctponly	9/sctp
+`
+	catalogue := NewDefaultCatalogue()
+	if err := catalogue.LoadServices(strings.NewReader(services)); err != nil {
+		t.Fatalf("LoadServices returned error: %v", err)
+	}
+	tests := map[string]string{
+		"postgresql": "5432 tcp",
+		"postgres":   "5432 tcp",
+		"cassandra":  "9042 tcp",
+		"rtp":        "5004 udp",
+		"snmp":       "161 udp",
+	}
+	for service, expected := range tests {
+		port, _ := catalogue.Port(service)
+		actual := strconv.Itoa(port) + " " + catalogue.Protocol(service)
+		if actual != expected {
+			t.Errorf("Service %s, expected %s, got %s", service, expected, actual)
+		}
+	}
+	if _, ok := catalogue.Port("sctponly"); ok {
+		t.Errorf("Expected sctp services to be skipped")
+	}
+
+	if err := catalogue.LoadServices(strings.NewReader("broken\n")); err == nil {
+		t.Errorf("Expected an error for a line without a port")
+	}
+	if err := catalogue.LoadServices(strings.NewReader("big 70000/tcp\n")); err == nil {
+		t.Errorf("Expected an error for a port out of range")
+	}
+}
+
+func Test_Catalogue_LoadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flower")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"services.json": `{"cassandra": 9042, "rtp": "5004/udp"}`,
+		"services.yaml": "---\n# extra services\ncassandra: 9042\nrtp: \"5004/udp\"  # media\n",
+		"services":      "cassandra 9042/tcp\nrtp 5004/udp\n",
+	}
+	for name, content := range files {
+		filename := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		catalogue := NewCatalogue()
+		if err := catalogue.LoadFile(filename); err != nil {
+			t.Errorf("File %s, LoadFile returned error: %v", name, err)
+			continue
+		}
+		if port, ok := catalogue.Port("cassandra"); !ok || port != 9042 || catalogue.Protocol("cassandra") != "tcp" {
+			t.Errorf("File %s, expected cassandra 9042 tcp", name)
+		}
+		if port, ok := catalogue.Port("rtp"); !ok || port != 5004 || catalogue.Protocol("rtp") != "udp" {
+			t.Errorf("File %s, expected rtp 5004 udp", name)
+		}
+	}
+
+	if err := NewCatalogue().LoadJSON(strings.NewReader(`{"bad": true}`)); err == nil {
+		t.Errorf("Expected an error for an invalid JSON port")
+	}
+	if err := NewCatalogue().LoadYAML(strings.NewReader("bad: 5004/smtp\n")); err == nil {
+		t.Errorf("Expected an error for an unknown protocol")
+	}
+}
+
+func Test_Registry_Catalogue(t *testing.T) {
+	interpreter := NewInterpreter()
+	interpreter.Registry().Catalogue().Add("cassandra", 9042, "")
+	cmd, ok := interpreter.EvaluateCode("flower: db offers cassandra").(*ServiceCommand)
+	if !ok || cmd.port != 9042 || cmd.protocol != "tcp" {
+		t.Errorf("Expected cassandra on port 9042, got %v", cmd)
+	}
+	if _, ok := NewInterpreter().EvaluateCode("flower: db offers cassandra").(*SyntaxErrorCommand); !ok {
+		t.Errorf("Expected other interpreters not to know cassandra")
+	}
+}
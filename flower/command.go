@@ -1,14 +1,50 @@
 package flower
 
-import ()
+import (
+	"crypto/x509"
+	"time"
+)
+
+// Default time to wait for a check to connect
+const DEFAULT_TIMEOUT = 5 * time.Second
+
+// Default number of times a failed check is retried
+const DEFAULT_RETRIES = 0
 
 type Command interface {
 	// Execute exectutes the command.
-	Execute()
+	Execute(settings *Settings)
 
 	// Return HTML class(es) to be applied to a command
 	HtmlClass() string
 
 	// Return a string description of the command
 	String() string
+
+	// Return the outcome of the command, for use in reports
+	Report() Result
+}
+
+// Settings shared by all the commands executed by an interpreter. Values set
+// on an individual directive take precedence over these.
+type Settings struct {
+	// How long to wait for a connection before giving up
+	Timeout time.Duration
+
+	// How many times to retry a check that does not succeed
+	Retries int
+
+	// How commands reach the network. When nil the real network is used
+	Prober Prober
+
+	// Certificates that TLS checks trust. When nil the system's are used
+	RootCAs *x509.CertPool
+}
+
+// Return the Prober commands should use
+func (settings *Settings) prober() Prober {
+	if settings.Prober == nil {
+		return NetProber{}
+	}
+	return settings.Prober
 }
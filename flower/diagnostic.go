@@ -0,0 +1,98 @@
+package flower
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The largest port number
+const MAX_PORT = 65535
+
+// The most times a check may be retried. Each retry waits for the timeout
+// again, so a large count on a filtered port would hold up the render
+const MAX_RETRIES = 10
+
+// Diagnostic describes a line that looks like a flower directive, but is not
+// a valid one
+type Diagnostic struct {
+	// The line and column in the document where the problem starts, both
+	// counting from 1. When the interpreter was not told where the code is,
+	// see SetPosition, the line counts the lines of code evaluated and the
+	// column is within the directive
+	Line   int
+	Column int
+
+	// The directive as written
+	Directive string
+
+	// What is wrong, eg: unknown verb
+	Reason string
+}
+
+func (diagnostic Diagnostic) String() string {
+	return "line " + strconv.Itoa(diagnostic.Line) + ", column " + strconv.Itoa(diagnostic.Column) + ": " + diagnostic.Reason
+}
+
+// Splits a directive into its first two words, the subject and verb
+var directiveWords = regexp.MustCompile(`^\s*flower:\s*(\S+)?\s*(\S+)?`)
+
+// Return the reason a line that starts with flower: matches none of the
+// directives, and the column where the problem starts. knownVerb says whether
+// any directive uses a verb
+func unmatchedReason(line string, knownVerb func(verb string) bool) (string, int) {
+	words := directiveWords.FindStringSubmatchIndex(line)
+	switch {
+	case words[2] < 0:
+		return "missing host", words[1] + 1
+	case words[4] < 0:
+		return "missing verb", words[3] + 1
+	case !knownVerb(line[words[4]:words[5]]):
+		return "unknown verb " + strconv.Quote(line[words[4]:words[5]]), words[4] + 1
+	}
+	return "malformed " + line[words[4]:words[5]] + " directive", words[4] + 1
+}
+
+// Check the arguments captured from a directive, returning the reason they
+// are invalid and the name of the argument at fault, or "" if they are valid.
+// A service without a port must be in the catalogue
+func checkParams(params map[string]string, catalogue *Catalogue) (string, string) {
+	if params["port"] != "" {
+		port, err := strconv.Atoi(params["port"])
+		if err != nil || port < 1 || port > MAX_PORT {
+			return "port out of range", "port"
+		}
+	} else if service, ok := params["service"]; ok {
+		if _, known := catalogue.Port(service); !known {
+			return "unknown service with no port", "service"
+		}
+	}
+	if params["status"] != "" {
+		status, _ := strconv.Atoi(params["status"])
+		if status < 100 || status > 599 {
+			return "status out of range", "status"
+		}
+	}
+	if params["timeout"] != "" {
+		if timeout, err := time.ParseDuration(params["timeout"]); err != nil || timeout <= 0 {
+			return "invalid timeout", "timeout"
+		}
+	}
+	if params["retries"] != "" {
+		if retries, err := strconv.Atoi(params["retries"]); err != nil {
+			return "invalid retries", "retries"
+		} else if retries > MAX_RETRIES {
+			return "more than " + strconv.Itoa(MAX_RETRIES) + " retries", "retries"
+		}
+	}
+	options := strings.Fields(params["certoptions"])
+	for i := 0; i+1 < len(options); i += 2 {
+		if options[i] == "cert-valid-for" {
+			if _, err := ParseValidity(options[i+1]); err != nil {
+				return "invalid certificate validity", "certoptions"
+			}
+		}
+	}
+	return "", ""
+}
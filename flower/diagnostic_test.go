@@ -0,0 +1,80 @@
+package flower
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_ParseDirective_Diagnostics(t *testing.T) {
+	tests := []struct {
+		input  string
+		column int
+		reason string
+	}{
+		{"flower: web serves http", 13, `unknown verb "serves"`},
+		{"flower: web", 12, "missing verb"},
+		{"flower:", 8, "missing host"},
+		{"flower: web offers http:70000", 25, "port out of range"},
+		{"flower: web uses postgres:0 at db", 27, "port out of range"},
+		{"flower: web offers gopher", 20, "unknown service with no port"},
+		{"  flower: web uses gopher at db", 20, "unknown service with no port"},
+		{"flower: web offers http timeout never", 33, "invalid timeout"},
+		{"flower: web offers http path / expect 999", 39, "status out of range"},
+		{"flower: web offers https cert-valid-for soon", 25, "invalid certificate validity"},
+		{"flower: web offers", 13, "malformed offers directive"},
+	}
+	for _, test := range tests {
+		cmd, diagnostic := ParseDirective(test.input)
+		if cmd != nil || diagnostic == nil {
+			t.Errorf("Input %s, expected a diagnostic, got %v", test.input, cmd)
+			continue
+		}
+		if diagnostic.Column != test.column || diagnostic.Reason != test.reason || diagnostic.Directive != test.input {
+			t.Errorf("Input %s, expected column %d %s, got %+v", test.input, test.column, test.reason, diagnostic)
+		}
+	}
+
+	for _, input := range []string{"flower: web offers http:8080", "flower: web offers udp:514", "not flower: at all", ""} {
+		if _, diagnostic := ParseDirective(input); diagnostic != nil {
+			t.Errorf("Input %s, unexpected diagnostic %+v", input, diagnostic)
+		}
+	}
+}
+
+func Test_Interpreter_Diagnostics(t *testing.T) {
+	interpreter := NewInterpreter()
+	interpreter.SetDryRun(true)
+	interpreter.EvaluateCode("flower: web offers http")
+	interpreter.EvaluateCode("some other code")
+	cmd := interpreter.EvaluateCode("flower: web offers http:0")
+	interpreter.EvaluateCode("flower: web is here")
+	interpreter.Run()
+
+	diagnostics := interpreter.Diagnostics()
+	if len(diagnostics) != 2 {
+		t.Fatalf("Expected 2 diagnostics, got %v", diagnostics)
+	}
+	if diagnostics[0].Line != 3 || diagnostics[0].String() != "line 3, column 25: port out of range" {
+		t.Errorf("Unexpected diagnostic %s", diagnostics[0])
+	}
+	if diagnostics[1].Line != 4 || diagnostics[1].Reason != "malformed is directive" {
+		t.Errorf("Unexpected diagnostic %s", diagnostics[1])
+	}
+	if cmd.String() != "syntax error: line 3, column 25: port out of range" {
+		t.Errorf("Unexpected command %s", cmd)
+	}
+
+	// positions in the document, when the interpreter is told them
+	interpreter.SetPosition(12, 5)
+	interpreter.EvaluateCode("flower: web offers http")
+	interpreter.EvaluateCode("flower: web offers http:0")
+	if diagnostics := interpreter.Diagnostics(); diagnostics[2].String() != "line 13, column 29: port out of range" {
+		t.Errorf("Unexpected diagnostic %s", diagnostics[2])
+	}
+
+	summary := string(interpreter.SummaryReport())
+	if !strings.Contains(summary, "<tr><th>Diagnostics</th></tr>") ||
+		!strings.Contains(summary, "line 3, column 25: port out of range: flower: web offers http:0") {
+		t.Errorf("Diagnostics missing from summary %s", summary)
+	}
+}
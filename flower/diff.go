@@ -0,0 +1,146 @@
+package flower
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"strconv"
+	"time"
+)
+
+// A check is slower when it takes at least this many times as long as it
+// did before
+const SLOWER_FACTOR = 1.5
+
+// and at least this much longer, so that small changes in fast checks are
+// not reported
+const SLOWER_MINIMUM = 10 * time.Millisecond
+
+// ResultChange is a check as it was in one run, and as it is in another
+type ResultChange struct {
+	Before Result
+	After  Result
+}
+
+// Return true if the check was OK, and is not any more
+func (change ResultChange) Regressed() bool {
+	return change.Before.Result == OK && change.After.Result != OK
+}
+
+// Diff lists the differences between two runs of a document's checks. Checks
+// are matched by their directive
+type Diff struct {
+	// Checks whose result changed, eg: from OK to FAIL
+	Changed []ResultChange
+
+	// Checks only in the later run, and only in the earlier run
+	Added   []Result
+	Removed []Result
+
+	// Checks whose result did not change, but which took longer
+	Slower []ResultChange
+}
+
+// Return true if there are no differences
+func (diff Diff) Empty() bool {
+	return len(diff.Changed) == 0 && len(diff.Added) == 0 && len(diff.Removed) == 0 && len(diff.Slower) == 0
+}
+
+// Return the checks that were OK before, and are not now
+func (diff Diff) Regressions() []ResultChange {
+	var regressions []ResultChange
+	for _, change := range diff.Changed {
+		if change.Regressed() {
+			regressions = append(regressions, change)
+		}
+	}
+	return regressions
+}
+
+// Read results written by WriteJSON
+func ReadJSON(r io.Reader) ([]Result, error) {
+	var objects []jsonResult
+	if err := json.NewDecoder(r).Decode(&objects); err != nil {
+		return nil, err
+	}
+	results := make([]Result, 0, len(objects))
+	for _, object := range objects {
+		results = append(results, object.result())
+	}
+	return results, nil
+}
+
+// Return the key a result is matched by: its directive, and which occurrence
+// of the directive it is, for documents that repeat a directive
+func diffKeys(results []Result) []string {
+	keys := make([]string, len(results))
+	seen := make(map[string]int)
+	for i, result := range results {
+		keys[i] = result.Directive + "\x00" + strconv.Itoa(seen[result.Directive])
+		seen[result.Directive]++
+	}
+	return keys
+}
+
+// Compare two runs of the checks, in the order of the later run
+func Compare(before []Result, after []Result) Diff {
+	var diff Diff
+	earlier := make(map[string]Result)
+	beforeKeys := diffKeys(before)
+	for i, result := range before {
+		earlier[beforeKeys[i]] = result
+	}
+
+	later := make(map[string]bool)
+	for i, key := range diffKeys(after) {
+		result := after[i]
+		later[key] = true
+		previous, ok := earlier[key]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, result)
+		case previous.Result != result.Result:
+			diff.Changed = append(diff.Changed, ResultChange{previous, result})
+		case slower(previous.Duration, result.Duration):
+			diff.Slower = append(diff.Slower, ResultChange{previous, result})
+		}
+	}
+	for i, key := range beforeKeys {
+		if !later[key] {
+			diff.Removed = append(diff.Removed, before[i])
+		}
+	}
+	return diff
+}
+
+// Return true if a check that took before now takes after, and is slower
+func slower(before time.Duration, after time.Duration) bool {
+	return after-before >= SLOWER_MINIMUM && float64(after) >= float64(before)*SLOWER_FACTOR
+}
+
+// Write the differences as rows of an HTML table
+func (diff Diff) writeHtml(buf *bytes.Buffer) {
+	fmt.Fprintln(buf, "<tr><th>Changes</th></tr>")
+	if diff.Empty() {
+		fmt.Fprintln(buf, "<tr><td>No changes</td></tr>")
+		return
+	}
+	for _, change := range diff.Changed {
+		class := "FLOWER-CHANGED"
+		if change.Regressed() {
+			class += " FLOWER-REGRESSED"
+		}
+		fmt.Fprintln(buf, "<tr><td class=\""+class+"\">", html.EscapeString(ResultName(change.Before.Result)+" → "+ResultName(change.After.Result)+": "+change.After.Directive), "</td></tr>")
+	}
+	for _, result := range diff.Added {
+		fmt.Fprintln(buf, "<tr><td class=\"FLOWER-ADDED\">", html.EscapeString("added "+ResultName(result.Result)+": "+result.Directive), "</td></tr>")
+	}
+	for _, result := range diff.Removed {
+		fmt.Fprintln(buf, "<tr><td class=\"FLOWER-REMOVED\">", html.EscapeString("removed: "+result.Directive), "</td></tr>")
+	}
+	for _, change := range diff.Slower {
+		fmt.Fprintln(buf, "<tr><td class=\"FLOWER-SLOWER\">", html.EscapeString(change.Before.Duration.String()+" → "+change.After.Duration.String()+": "+change.After.Directive), "</td></tr>")
+	}
+}
@@ -0,0 +1,95 @@
+package flower
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Compare(t *testing.T) {
+	before := []Result{
+		{Directive: "flower: a offers http", Result: OK, Duration: 10 * time.Millisecond},
+		{Directive: "flower: b offers http", Result: OK, Duration: 10 * time.Millisecond},
+		{Directive: "flower: c offers http", Result: FAIL},
+		{Directive: "flower: d offers http", Result: OK, Duration: 10 * time.Millisecond},
+		{Directive: "flower: e offers http", Result: OK, Duration: time.Millisecond},
+		{Directive: "flower: gone offers http", Result: OK},
+		{Directive: "flower: twice offers http", Result: OK},
+	}
+	after := []Result{
+		{Directive: "flower: new offers http", Result: FAIL},
+		{Directive: "flower: a offers http", Result: FAIL, Duration: 10 * time.Millisecond},
+		{Directive: "flower: b offers http", Result: OK, Duration: 12 * time.Millisecond},
+		{Directive: "flower: c offers http", Result: OK},
+		{Directive: "flower: d offers http", Result: OK, Duration: 40 * time.Millisecond},
+		{Directive: "flower: e offers http", Result: OK, Duration: 5 * time.Millisecond},
+		{Directive: "flower: twice offers http", Result: OK},
+		{Directive: "flower: twice offers http", Result: OK},
+	}
+	diff := Compare(before, after)
+
+	if len(diff.Changed) != 2 || diff.Changed[0].After.Directive != "flower: a offers http" || diff.Changed[1].After.Directive != "flower: c offers http" {
+		t.Errorf("Unexpected changes %+v", diff.Changed)
+	}
+	if regressions := diff.Regressions(); len(regressions) != 1 || regressions[0].Before.Directive != "flower: a offers http" {
+		t.Errorf("Unexpected regressions %+v", regressions)
+	}
+	if len(diff.Added) != 2 || diff.Added[0].Directive != "flower: new offers http" || diff.Added[1].Directive != "flower: twice offers http" {
+		t.Errorf("Unexpected additions %+v", diff.Added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0].Directive != "flower: gone offers http" {
+		t.Errorf("Unexpected removals %+v", diff.Removed)
+	}
+	if len(diff.Slower) != 1 || diff.Slower[0].After.Directive != "flower: d offers http" {
+		t.Errorf("Unexpected slower checks %+v", diff.Slower)
+	}
+	if diff.Empty() || !Compare(after, after).Empty() {
+		t.Errorf("Expected only identical runs to have no differences")
+	}
+}
+
+func Test_Snapshot(t *testing.T) {
+	var snapshot bytes.Buffer
+	first := reportInterpreter()
+	if err := WriteJSON(&snapshot, first.Results()); err != nil {
+		t.Fatal(err)
+	}
+	baseline, err := ReadJSON(&snapshot)
+	if err != nil {
+		t.Fatalf("ReadJSON returned error: %v", err)
+	}
+	if len(baseline) != 5 || baseline[0].Result != OK || baseline[0].Match != MATCH_HOST || baseline[4].Err == nil {
+		t.Errorf("Unexpected snapshot %+v", baseline)
+	}
+
+	// port 80 has closed since the snapshot
+	prober := testProber()
+	prober.Filter("tcp", "10.0.0.2:8080")
+	interpreter := NewInterpreter()
+	interpreter.SetProber(prober)
+	interpreter.SetBaseline(baseline)
+	interpreter.EvaluateCode("flower: here offers http")
+	interpreter.EvaluateCode("flower: here offers http:8080")
+	interpreter.EvaluateCode("flower: here offers ssh")
+	interpreter.Run()
+
+	diff := interpreter.Compare()
+	if len(diff.Regressions()) != 1 || len(diff.Added) != 1 || len(diff.Removed) != 3 {
+		t.Errorf("Unexpected differences %+v", diff)
+	}
+	summary := string(interpreter.SummaryReport())
+	for _, expected := range []string{
+		"<tr><th>Changes</th></tr>",
+		"<tr><td class=\"FLOWER-CHANGED FLOWER-REGRESSED\"> OK → FAIL: flower: here offers http </td></tr>",
+		"<tr><td class=\"FLOWER-ADDED\"> added FAIL: flower: here offers ssh </td></tr>",
+		"<tr><td class=\"FLOWER-REMOVED\"> removed: flower: nowhere offers http </td></tr>",
+	} {
+		if !strings.Contains(summary, expected) {
+			t.Errorf("Expected %s in %s", expected, summary)
+		}
+	}
+	if strings.Contains(string(NewInterpreter().SummaryReport()), "Changes") {
+		t.Errorf("Expected no changes without a baseline")
+	}
+}
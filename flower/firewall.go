@@ -0,0 +1,249 @@
+package flower
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Rule allows a caller to reach a service on a host, as described by a
+// `uses` directive
+type Rule struct {
+	// The host that calls the service, and its address: a CIDR block when the
+	// caller is an alias, otherwise the caller's name
+	Caller string
+	Source string
+
+	// The host that offers the service, and its address
+	Host        string
+	Destination string
+
+	// The transport protocol, tcp or udp, the service name and port
+	Protocol string
+	Service  string
+	Port     int
+}
+
+// Return a description of a rule, eg: web uses postgres
+func (rule Rule) Comment() string {
+	return rule.Caller + " uses " + rule.Service
+}
+
+// Return the rules described by the `uses` directives seen so far, in
+// document order, whether or not they have been run. Aliases are resolved to
+// their addresses
+func (interpreter *StandardInterpreter) Rules() []Rule {
+	var rules []Rule
+	for element := interpreter.commands.Front(); element != nil; element = element.Next() {
+		cmd, ok := element.Value.(*entry).command.(*ServiceCommand)
+		if !ok || !cmd.uses {
+			continue
+		}
+		rules = append(rules, Rule{
+			Caller:      cmd.caller,
+			Source:      ruleAddress(cmd.callerAddr()),
+			Host:        cmd.host,
+			Destination: ruleAddress(cmd.hostAddr()),
+			Protocol:    ruleProtocol(cmd.protocol),
+			Service:     cmd.service,
+			Port:        cmd.port,
+		})
+	}
+	return rules
+}
+
+// Return the address a rule uses for a host: its CIDR block when it is an
+// address or address pattern, otherwise the host name unchanged
+func ruleAddress(host string) string {
+	if cidr, ok := AddressCIDR(host); ok {
+		return cidr
+	}
+	return host
+}
+
+// Return the transport protocol used by a probe protocol
+func ruleProtocol(protocol string) string {
+	if protocol == "udp" || protocol == "dns" {
+		return "udp"
+	}
+	return "tcp"
+}
+
+// Return the CIDR block for an IP address or address pattern, eg: 10.0.0.5
+// is 10.0.0.5/32, and 10.0.* is 10.0.0.0/16. Returns false for a host name,
+// or a pattern with a wildcard before its last part
+func AddressCIDR(address string) (string, bool) {
+	if ip := net.ParseIP(address); ip != nil {
+		if ip.To4() != nil {
+			return ip.String() + "/32", true
+		}
+		return ip.String() + "/128", true
+	}
+	if !IsAddressPattern(address) {
+		return "", false
+	}
+
+	parts := strings.Split(address, ".")
+	if len(parts) > 4 {
+		return "", false
+	}
+	octets := []string{"0", "0", "0", "0"}
+	bits := 0
+	wild := false
+	for i, part := range parts {
+		if part == "*" {
+			wild = true
+			continue
+		}
+		octet, err := strconv.Atoi(part)
+		if wild || err != nil || octet < 0 || octet > 255 {
+			return "", false
+		}
+		octets[i] = part
+		bits += 8
+	}
+	return strings.Join(octets, ".") + "/" + strconv.Itoa(bits), true
+}
+
+// Return the rules grouped by the host that offers the service, in the order
+// the hosts first appear
+func groupRules(rules []Rule) ([]string, map[string][]Rule) {
+	var hosts []string
+	groups := make(map[string][]Rule)
+	for _, rule := range rules {
+		if _, ok := groups[rule.Host]; !ok {
+			hosts = append(hosts, rule.Host)
+		}
+		groups[rule.Host] = append(groups[rule.Host], rule)
+	}
+	return hosts, groups
+}
+
+// Return the hosts that offer the services the rules allow callers in to,
+// in the order they first appear. Each has its own rule set
+func RuleHosts(rules []Rule) []string {
+	hosts, _ := groupRules(rules)
+	return hosts
+}
+
+// Return the rules for one host, or an error if there are none
+func hostRules(rules []Rule, host string) ([]Rule, error) {
+	_, groups := groupRules(rules)
+	if len(groups[host]) == 0 {
+		return nil, fmt.Errorf("no rules for %s", host)
+	}
+	return groups[host], nil
+}
+
+// Write the rules for one host as an iptables-restore rule set, allowing each
+// caller in to the services the host offers. A rule set is loaded on the
+// host it is for, so each host is written on its own; see RuleHosts
+func WriteIptables(w io.Writer, rules []Rule, host string) error {
+	rules, err := hostRules(rules, host)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# host: %s (%s)\n", host, rules[0].Destination)
+	buf.WriteString("*filter\n")
+	for _, rule := range rules {
+		fmt.Fprintf(&buf, "-A INPUT -p %s -s %s --dport %d -m comment --comment %s -j ACCEPT\n",
+			rule.Protocol, rule.Source, rule.Port, strconv.Quote(rule.Comment()))
+	}
+	buf.WriteString("COMMIT\n")
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// Write the rules for one host as an nftables rule set, allowing each caller
+// in to the services the host offers. Like WriteIptables, each host is
+// written on its own
+func WriteNftables(w io.Writer, rules []Rule, host string) error {
+	rules, err := hostRules(rules, host)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# host: %s (%s)\n", host, rules[0].Destination)
+	buf.WriteString("table inet flower {\n")
+	buf.WriteString("  chain input {\n")
+	buf.WriteString("    type filter hook input priority 0;\n")
+	for _, rule := range rules {
+		family := "ip"
+		if strings.Contains(rule.Source, ":") {
+			family = "ip6"
+		}
+		fmt.Fprintf(&buf, "    %s saddr %s %s dport %d accept comment %s\n",
+			family, rule.Source, rule.Protocol, rule.Port, strconv.Quote(rule.Comment()))
+	}
+	buf.WriteString("  }\n")
+	buf.WriteString("}\n")
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// The form of rules in an AWS security group
+type securityGroup struct {
+	GroupName     string         `json:"GroupName"`
+	Description   string         `json:"Description"`
+	IpPermissions []ipPermission `json:"IpPermissions"`
+}
+
+type ipPermission struct {
+	IpProtocol string      `json:"IpProtocol"`
+	FromPort   int         `json:"FromPort"`
+	ToPort     int         `json:"ToPort"`
+	IpRanges   []ipRange   `json:"IpRanges,omitempty"`
+	Ipv6Ranges []ipv6Range `json:"Ipv6Ranges,omitempty"`
+}
+
+type ipRange struct {
+	CidrIp      string `json:"CidrIp"`
+	Description string `json:"Description"`
+}
+
+type ipv6Range struct {
+	CidrIpv6    string `json:"CidrIpv6"`
+	Description string `json:"Description"`
+}
+
+// Write the rules as a JSON array of AWS security groups, one for each host,
+// with an ingress permission for each caller. Security groups only allow
+// addresses, so a caller must be an alias for an address or address pattern
+func WriteSecurityGroups(w io.Writer, rules []Rule) error {
+	groups := []securityGroup{}
+	hosts, grouped := groupRules(rules)
+	for _, host := range hosts {
+		group := securityGroup{
+			GroupName:     "flower-" + host,
+			Description:   "Flower rules for " + host,
+			IpPermissions: []ipPermission{},
+		}
+		for _, rule := range grouped[host] {
+			_, _, err := net.ParseCIDR(rule.Source)
+			if err != nil {
+				return fmt.Errorf("no address for %s, which uses %s at %s", rule.Caller, rule.Service, rule.Host)
+			}
+			permission := ipPermission{IpProtocol: rule.Protocol, FromPort: rule.Port, ToPort: rule.Port}
+			if strings.Contains(rule.Source, ":") {
+				permission.Ipv6Ranges = []ipv6Range{{rule.Source, rule.Comment()}}
+			} else {
+				permission.IpRanges = []ipRange{{rule.Source, rule.Comment()}}
+			}
+			group.IpPermissions = append(group.IpPermissions, permission)
+		}
+		groups = append(groups, group)
+	}
+
+	data, err := json.MarshalIndent(groups, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,161 @@
+package flower
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func Test_AddressCIDR(t *testing.T) {
+	tests := map[string]string{
+		"10.0.0.5":   "10.0.0.5/32",
+		"10.0.*":     "10.0.0.0/16",
+		"10.0.0.*":   "10.0.0.0/24",
+		"10.*.*.*":   "10.0.0.0/8",
+		"*":          "0.0.0.0/0",
+		"::1":        "::1/128",
+		"10.*.0.1":   "",
+		"10.0.300.*": "",
+		"db":         "",
+	}
+	for input, expected := range tests {
+		actual, ok := AddressCIDR(input)
+		if actual != expected || ok != (expected != "") {
+			t.Errorf("Input %s, expected %s, got %s %v", input, expected, actual, ok)
+		}
+	}
+}
+
+func firewallInterpreter() *StandardInterpreter {
+	interpreter := NewInterpreter()
+	interpreter.EvaluateCode("flower: 10.0.* is web")
+	interpreter.EvaluateCode("flower: 10.1.0.5 is db")
+	interpreter.EvaluateCode("flower: web uses postgres:5432 at db")
+	interpreter.EvaluateCode("flower: db offers postgres:5432")
+	interpreter.EvaluateCode("flower: web uses dns at 10.1.0.53")
+	interpreter.EvaluateCode("flower: web uses syslog at db")
+	return interpreter
+}
+
+func Test_Rules(t *testing.T) {
+	rules := firewallInterpreter().Rules()
+	expected := []Rule{
+		{"web", "10.0.0.0/16", "db", "10.1.0.5/32", "tcp", "postgres", 5432},
+		{"web", "10.0.0.0/16", "10.1.0.53", "10.1.0.53/32", "udp", "dns", 53},
+		{"web", "10.0.0.0/16", "db", "10.1.0.5/32", "udp", "syslog", 514},
+	}
+	if len(rules) != len(expected) {
+		t.Fatalf("Expected %d rules, got %v", len(expected), rules)
+	}
+	for i := range expected {
+		if rules[i] != expected[i] {
+			t.Errorf("Expected %+v, got %+v", expected[i], rules[i])
+		}
+	}
+}
+
+// Rules come from the directives, not from how their checks went
+func Test_RulesAfterRun(t *testing.T) {
+	prober := NewScriptedProber()
+	prober.AddLocal("10.0.0.9")
+	interpreter := NewInterpreter()
+	interpreter.SetProber(prober)
+	interpreter.EvaluateCode("flower: 10.1.0.5 is db")
+	interpreter.EvaluateCode("flower: web uses postgres:5432 at no.such.host")
+	interpreter.EvaluateCode("flower: db uses redis:6379 at db")
+	interpreter.EvaluateCode("flower: db offers postgres:5432")
+	interpreter.Run()
+
+	rules := interpreter.Rules()
+	expected := []Rule{
+		{"web", "web", "no.such.host", "no.such.host", "tcp", "postgres", 5432},
+		{"db", "10.1.0.5/32", "db", "10.1.0.5/32", "tcp", "redis", 6379},
+	}
+	if len(rules) != len(expected) {
+		t.Fatalf("Expected %d rules, got %v", len(expected), rules)
+	}
+	for i := range expected {
+		if rules[i] != expected[i] {
+			t.Errorf("Expected %+v, got %+v", expected[i], rules[i])
+		}
+	}
+}
+
+func Test_WriteIptables(t *testing.T) {
+	rules := firewallInterpreter().Rules()
+	if hosts := RuleHosts(rules); len(hosts) != 2 || hosts[0] != "db" || hosts[1] != "10.1.0.53" {
+		t.Errorf("Unexpected hosts %v", hosts)
+	}
+
+	// each host's rule set is loaded on that host, so is written on its own
+	tests := map[string]string{
+		"db": `# host: db (10.1.0.5/32)
+*filter
+-A INPUT -p tcp -s 10.0.0.0/16 --dport 5432 -m comment --comment "web uses postgres" -j ACCEPT
+-A INPUT -p udp -s 10.0.0.0/16 --dport 514 -m comment --comment "web uses syslog" -j ACCEPT
+COMMIT
+`,
+		"10.1.0.53": `# host: 10.1.0.53 (10.1.0.53/32)
+*filter
+-A INPUT -p udp -s 10.0.0.0/16 --dport 53 -m comment --comment "web uses dns" -j ACCEPT
+COMMIT
+`,
+	}
+	for host, expected := range tests {
+		var buf bytes.Buffer
+		if err := WriteIptables(&buf, rules, host); err != nil {
+			t.Fatalf("WriteIptables returned error: %v", err)
+		}
+		if buf.String() != expected {
+			t.Errorf("Expected:\n%s\nGot:\n%s", expected, buf.String())
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := WriteIptables(&buf, rules, "web"); err == nil || buf.Len() != 0 {
+		t.Errorf("Expected an error for a host without rules, got %v [%s]", err, buf.String())
+	}
+}
+
+func Test_WriteNftables(t *testing.T) {
+	var buf bytes.Buffer
+	rules := []Rule{{"web", "fd00::/8", "db", "fd00::5/128", "tcp", "postgres", 5432}}
+	if err := WriteNftables(&buf, rules, "db"); err != nil {
+		t.Fatalf("WriteNftables returned error: %v", err)
+	}
+	expected := `# host: db (fd00::5/128)
+table inet flower {
+  chain input {
+    type filter hook input priority 0;
+    ip6 saddr fd00::/8 tcp dport 5432 accept comment "web uses postgres"
+  }
+}
+`
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, buf.String())
+	}
+}
+
+func Test_WriteSecurityGroups(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteSecurityGroups(&buf, firewallInterpreter().Rules()); err != nil {
+		t.Fatalf("WriteSecurityGroups returned error: %v", err)
+	}
+	var groups []securityGroup
+	if err := json.Unmarshal(buf.Bytes(), &groups); err != nil {
+		t.Fatalf("WriteSecurityGroups wrote invalid JSON: %v\n%s", err, buf.String())
+	}
+	if len(groups) != 2 || groups[0].GroupName != "flower-db" || len(groups[0].IpPermissions) != 2 {
+		t.Fatalf("Unexpected groups %s", buf.String())
+	}
+	permission := groups[0].IpPermissions[0]
+	if permission.IpProtocol != "tcp" || permission.FromPort != 5432 || permission.ToPort != 5432 ||
+		permission.IpRanges[0].CidrIp != "10.0.0.0/16" {
+		t.Errorf("Unexpected permission %+v", permission)
+	}
+
+	unresolved := []Rule{{"web", "web", "db", "db", "tcp", "postgres", 5432}}
+	if err := WriteSecurityGroups(&buf, unresolved); err == nil {
+		t.Errorf("Expected an error for a caller without an address")
+	}
+}
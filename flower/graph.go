@@ -0,0 +1,123 @@
+package flower
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Edge is a dependency between two hosts, from the caller to the host that
+// offers the service. A host that offers a service without naming a caller
+// has an edge to itself
+type Edge struct {
+	Caller  string
+	Host    string
+	Service string
+	Port    int
+
+	// The result of the last command for this edge
+	Result int
+}
+
+// Return the label of an edge, eg: postgres:5432
+func (edge Edge) Label() string {
+	return edge.Service + ":" + strconv.Itoa(edge.Port)
+}
+
+// Return the edges of the graph described by a set of results, in the order
+// they first appear. When several commands describe the same edge, the last
+// result is used. Aliases are not edges
+func Edges(results []Result) []Edge {
+	var edges []Edge
+	index := make(map[Edge]int)
+	for _, result := range results {
+		if result.Caller == "" || result.Host == "" {
+			continue
+		}
+		key := Edge{Caller: result.Caller, Host: result.Host, Service: result.Service, Port: result.Port}
+		if i, ok := index[key]; ok {
+			edges[i].Result = result.Result
+			continue
+		}
+		index[key] = len(edges)
+		key.Result = result.Result
+		edges = append(edges, key)
+	}
+	return edges
+}
+
+// Return the hosts joined by edges, in the order they first appear
+func nodes(edges []Edge) []string {
+	var hosts []string
+	seen := make(map[string]bool)
+	for _, edge := range edges {
+		for _, host := range []string{edge.Caller, edge.Host} {
+			if !seen[host] {
+				seen[host] = true
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	return hosts
+}
+
+// Return the colour an edge is drawn in for a result, matching the classes in
+// the HTML output
+func ResultColour(result int) string {
+	switch result {
+	case OK:
+		return "green"
+	case FAIL, ERROR:
+		return "red"
+	case TIMEOUT:
+		return "orange"
+	}
+	return "grey"
+}
+
+// Write the graph described by a set of results as a Graphviz DOT digraph
+func WriteDOT(w io.Writer, results []Result) error {
+	edges := Edges(results)
+	buf := bytes.NewBufferString("digraph flower {\n")
+	for _, host := range nodes(edges) {
+		fmt.Fprintf(buf, "  %s;\n", dotQuote(host))
+	}
+	for _, edge := range edges {
+		fmt.Fprintf(buf, "  %s -> %s [label=%s, color=%s];\n",
+			dotQuote(edge.Caller), dotQuote(edge.Host), dotQuote(edge.Label()), ResultColour(edge.Result))
+	}
+	buf.WriteString("}\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Return a DOT identifier as a quoted string
+func dotQuote(id string) string {
+	return "\"" + strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace(id) + "\""
+}
+
+// Write the graph described by a set of results as a Mermaid flowchart
+func WriteMermaid(w io.Writer, results []Result) error {
+	edges := Edges(results)
+	ids := make(map[string]string)
+	buf := bytes.NewBufferString("flowchart LR\n")
+	for i, host := range nodes(edges) {
+		ids[host] = "n" + strconv.Itoa(i)
+		fmt.Fprintf(buf, "  %s[%s]\n", ids[host], mermaidQuote(host))
+	}
+	for _, edge := range edges {
+		fmt.Fprintf(buf, "  %s -->|%s| %s\n", ids[edge.Caller], mermaidQuote(edge.Label()), ids[edge.Host])
+	}
+	for i, edge := range edges {
+		fmt.Fprintf(buf, "  linkStyle %d stroke:%s\n", i, ResultColour(edge.Result))
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Return Mermaid text as a quoted string
+func mermaidQuote(text string) string {
+	return "\"" + strings.Replace(text, "\"", "#quot;", -1) + "\""
+}
@@ -0,0 +1,77 @@
+package flower
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func graphResults() []Result {
+	return []Result{
+		{Host: "10.0.0.5", Service: "10.0.0.5", Port: 0, Caller: "", Result: OK},
+		{Host: "db", Service: "postgres", Port: 5432, Caller: "web", Result: FAIL},
+		{Host: "web", Service: "http", Port: 80, Caller: "web", Result: TIMEOUT},
+		{Host: "db", Service: "postgres", Port: 5432, Caller: "web", Result: OK},
+		{Host: "cache \"1\"", Service: "redis", Port: 6379, Caller: "web", Result: NO_ANSWER},
+	}
+}
+
+func Test_Edges(t *testing.T) {
+	edges := Edges(graphResults())
+	expected := []Edge{
+		{Caller: "web", Host: "db", Service: "postgres", Port: 5432, Result: OK},
+		{Caller: "web", Host: "web", Service: "http", Port: 80, Result: TIMEOUT},
+		{Caller: "web", Host: "cache \"1\"", Service: "redis", Port: 6379, Result: NO_ANSWER},
+	}
+	if len(edges) != len(expected) {
+		t.Fatalf("Expected %d edges, got %v", len(expected), edges)
+	}
+	for i := range expected {
+		if edges[i] != expected[i] {
+			t.Errorf("Expected %+v, got %+v", expected[i], edges[i])
+		}
+	}
+}
+
+func Test_WriteDOT(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteDOT(&buf, graphResults()); err != nil {
+		t.Fatalf("WriteDOT returned error: %v", err)
+	}
+	expected := `digraph flower {
+  "web";
+  "db";
+  "cache \"1\"";
+  "web" -> "db" [label="postgres:5432", color=green];
+  "web" -> "web" [label="http:80", color=orange];
+  "web" -> "cache \"1\"" [label="redis:6379", color=grey];
+}
+`
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, buf.String())
+	}
+}
+
+func Test_WriteMermaid(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteMermaid(&buf, graphResults()); err != nil {
+		t.Fatalf("WriteMermaid returned error: %v", err)
+	}
+	expected := `flowchart LR
+  n0["web"]
+  n1["db"]
+  n2["cache #quot;1#quot;"]
+  n0 -->|"postgres:5432"| n1
+  n0 -->|"http:80"| n0
+  n0 -->|"redis:6379"| n2
+  linkStyle 0 stroke:green
+  linkStyle 1 stroke:orange
+  linkStyle 2 stroke:grey
+`
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, buf.String())
+	}
+	if strings.Count(buf.String(), "linkStyle") != 3 {
+		t.Errorf("Expected a style for each edge")
+	}
+}
@@ -0,0 +1,127 @@
+package flower
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The most of a response body that is read when looking for the expected text
+const MAX_BODY_SIZE = 1 << 20
+
+// HttpCommand checks that a web service answers a request for a path with the
+// expected status code, or with a body containing the expected text
+type HttpCommand struct {
+	ServiceCommand
+
+	// The path requested, eg: /healthz
+	path string
+
+	// The expected status code, or 0 if any status will do
+	status int
+
+	// The text expected in the body, or "" if any body will do
+	body string
+
+	// Why the check failed
+	reason string
+}
+
+func NewHttpCommand(host string, service string, port int, path string, status int, body string) *HttpCommand {
+	cmd := new(HttpCommand)
+	cmd.ServiceCommand = *NewServiceCommand(host, service, port, host)
+	cmd.path = path
+	cmd.status = status
+	cmd.body = body
+	return cmd
+}
+
+func (cmd *HttpCommand) Execute(settings *Settings) {
+	prober := settings.prober()
+	target := cmd.locate(prober)
+	if target == "" {
+		return
+	}
+	timeout, retries := cmd.checkSettings(settings)
+
+	for attempt := 0; attempt <= retries; attempt++ {
+		cmd.result, cmd.reason = cmd.request(prober, target, timeout)
+		if cmd.result == OK {
+			return
+		}
+	}
+}
+
+// Make the request, returning the result and, if it was not OK, the reason why
+func (cmd *HttpCommand) request(prober Prober, target string, timeout time.Duration) (int, string) {
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return prober.Dial(network, address, timeout)
+			},
+			DisableKeepAlives: true,
+		},
+		// the status checked is the path's own, so redirects are not followed
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	url := cmd.service + "://" + net.JoinHostPort(target, strconv.Itoa(cmd.port)) + cmd.path
+	response, err := client.Get(url)
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return TIMEOUT, err.Error()
+		}
+		return FAIL, err.Error()
+	}
+	defer response.Body.Close()
+
+	if cmd.status != 0 && response.StatusCode != cmd.status {
+		return FAIL, fmt.Sprintf("status %d", response.StatusCode)
+	}
+	if cmd.body != "" {
+		body, err := ioutil.ReadAll(io.LimitReader(response.Body, MAX_BODY_SIZE))
+		if err != nil {
+			return FAIL, err.Error()
+		}
+		if !strings.Contains(string(body), cmd.body) {
+			return FAIL, fmt.Sprintf("body does not contain %q", cmd.body)
+		}
+	}
+	return OK, ""
+}
+
+// Take the result of a check run by the executor for a caller
+func (cmd *HttpCommand) applyResult(result Result, via string) {
+	cmd.ServiceCommand.applyResult(result, via)
+	cmd.reason = result.Reason
+}
+
+func (cmd *HttpCommand) Report() Result {
+	result := cmd.ServiceCommand.Report()
+	result.Reason = cmd.reason
+	return result
+}
+
+func (cmd *HttpCommand) String() string {
+	str := cmd.ServiceCommand.String()
+	str += ", path:" + cmd.path
+	if cmd.status != 0 {
+		str += ", expect:" + strconv.Itoa(cmd.status)
+	}
+	if cmd.body != "" {
+		str += ", expect:body " + strconv.Quote(cmd.body)
+	}
+	if cmd.reason != "" {
+		str += ", reason:" + cmd.reason
+	}
+	return str
+}
@@ -0,0 +1,72 @@
+package flower
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func Test_HttpCheck_Parse(t *testing.T) {
+	cmd, ok := Parse("flower: web1 offers http:8080 path /healthz expect 200").(*HttpCommand)
+	if !ok {
+		t.Fatalf("Expected an HttpCommand")
+	}
+	if cmd.host != "web1" || cmd.port != 8080 || cmd.path != "/healthz" || cmd.status != 200 || cmd.body != "" {
+		t.Errorf("Unexpected command %s", cmd)
+	}
+
+	cmd, ok = Parse(`flower: web1 offers https path / expect body "ok" timeout 2s`).(*HttpCommand)
+	if !ok {
+		t.Fatalf("Expected an HttpCommand")
+	}
+	if cmd.port != 443 || cmd.path != "/" || cmd.status != 0 || cmd.body != "ok" || cmd.timeout.String() != "2s" {
+		t.Errorf("Unexpected command %s", cmd)
+	}
+}
+
+func Test_HttpCheck_Execute(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz":
+			fmt.Fprint(w, "all ok")
+		case "/old":
+			http.Redirect(w, r, "/healthz", http.StatusMovedPermanently)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	address, _ := url.Parse(server.URL)
+
+	tests := []struct {
+		directive string
+		result    int
+	}{
+		{"path /healthz expect 200", OK},
+		{`path /healthz expect body "ok"`, OK},
+		{"path /missing expect 200", FAIL},
+		{"path /missing expect 404", OK},
+		{`path /healthz expect body "broken"`, FAIL},
+		{"path /old expect 301", OK},
+		{"path /old expect 200", FAIL},
+	}
+	for _, test := range tests {
+		directive := "flower: " + address.Hostname() + " offers http:" + address.Port() + " " + test.directive
+		cmd := Parse(directive)
+		cmd.Execute(&Settings{Timeout: DEFAULT_TIMEOUT})
+		if cmd.(*HttpCommand).result != test.result {
+			t.Errorf("Expected result %d, got %s. Input %s", test.result, cmd, directive)
+		}
+	}
+}
+
+func Test_HttpCheck_Refused(t *testing.T) {
+	prober := testProber()
+	cmd := NewHttpCommand("here", "http", 80, "/", 200, "")
+	cmd.Execute(&Settings{Timeout: DEFAULT_TIMEOUT, Prober: prober})
+	if cmd.match != MATCH_HOST || cmd.result != FAIL || cmd.reason == "" {
+		t.Errorf("Expected a refused connection to fail, got %s", cmd)
+	}
+}
@@ -3,40 +3,362 @@ package flower
 import (
 	"bytes"
 	"container/list"
+	"crypto/x509"
 	"fmt"
-//	"strings"
+	"html"
+	"strings"
+	"sync"
+	"time"
 )
 
+// Default number of commands that are executed at the same time
+const DEFAULT_CONCURRENCY = 8
+
 type Interpreter interface {
-	// Evaluate markdown code
-	EvaluateCode(markdown_code string)
+	// Evaluate a line of markdown code, returning the flower command it contains or nil
+	EvaluateCode(markdown_code string) Command
+
+	// Execute the commands evaluated so far
+	Run()
+
+	// Return the outcome of each command, in document order
+	Results() []Result
+
+	// Return the outcome of the document as a whole, under a policy
+	Verdict(policy Policy) Verdict
+
+	// Return the problems with the directives evaluated, in document order
+	Diagnostics() []Diagnostic
 
 	// Return a report
-	SummaryReport() string
+	SummaryReport() []byte
 }
 
 type StandardInterpreter struct {
+	// All the commands seen, in document order, as *entry
 	commands *list.List
+
+	// Commands that have been evaluated but not yet executed, in document order
+	pending []*entry
+
+	// Maximum number of commands executing at the same time
+	concurrency int
+
+	// Passed to each command when it is executed
+	settings Settings
+
+	// Map from alias to the address it stands for
+	aliases map[string]string
+
+	// The directives this interpreter understands
+	registry *Registry
+
+	// Map from caller to the executor that runs its checks, when the caller
+	// is not this machine
+	executors map[string]Executor
+
+	// When true commands are parsed and recorded, but never executed
+	dryRun bool
+
+	// The line in the document of the last line of code evaluated, and the
+	// column before the one its first character is in. Without SetPosition
+	// lines are numbered among the lines of code evaluated
+	line   int
+	column int
+
+	// Problems with the directives evaluated so far, in document order
+	diagnostics []Diagnostic
+
+	// The results of an earlier run to compare with, or nil
+	baseline []Result
+
+	// Variables passed to the interpreter, and those defined in the document
+	variables   map[string]string
+	definitions map[string]string
+}
+
+// A command, and what the interpreter knows about it
+type entry struct {
+	command   Command
+	directive string
+	duration  time.Duration
+
+	// The aliases defined before the command, and the values of the
+	// variables its directive refers to, for executors
+	aliases   map[string]string
+	variables map[string]string
+}
+
+// Implemented by commands that refer to hosts which may be aliases
+type aliasResolver interface {
+	resolveAliases(aliases map[string]string)
 }
 
-// Create and return an Interpreter
-func NewInterpreter() *StandardInterpreter {
+// Create and return an Interpreter. Variables, if given, may be used in
+// directives as ${name}, and take precedence over those defined in the
+// document and in the environment
+func NewInterpreter(variables ...map[string]string) *StandardInterpreter {
 	interpreter := StandardInterpreter{
-		commands: list.New(),
+		commands:    list.New(),
+		concurrency: DEFAULT_CONCURRENCY,
+		aliases:     make(map[string]string),
+		variables:   make(map[string]string),
+		definitions: make(map[string]string),
+		registry:    NewDefaultRegistry(),
+		executors:   make(map[string]Executor),
+		settings: Settings{
+			Timeout: DEFAULT_TIMEOUT,
+			Retries: DEFAULT_RETRIES,
+			Prober:  NetProber{},
+		},
+	}
+	for _, values := range variables {
+		for name, value := range values {
+			interpreter.variables[name] = value
+		}
 	}
 	return &interpreter
 }
 
-// Evaluate a chunk of Markdown code. If it contains flower directives then evaluate it
+// Set the maximum number of commands that Run will execute at the same time
+func (interpreter *StandardInterpreter) SetConcurrency(concurrency int) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	interpreter.concurrency = concurrency
+}
+
+// Set how long a check waits to connect, unless its directive says otherwise
+func (interpreter *StandardInterpreter) SetTimeout(timeout time.Duration) {
+	interpreter.settings.Timeout = timeout
+}
+
+// Set how many times a check is retried, unless its directive says
+// otherwise. At most MAX_RETRIES
+func (interpreter *StandardInterpreter) SetRetries(retries int) {
+	if retries < 0 {
+		retries = 0
+	}
+	if retries > MAX_RETRIES {
+		retries = MAX_RETRIES
+	}
+	interpreter.settings.Retries = retries
+}
+
+// Set how commands reach the network, eg: a ScriptedProber to run offline
+func (interpreter *StandardInterpreter) SetProber(prober Prober) {
+	interpreter.settings.Prober = prober
+}
+
+// Return the directives this interpreter understands. Each interpreter has its
+// own registry, starting with the built in commands, so commands may be added
+// or removed without affecting other interpreters
+func (interpreter *StandardInterpreter) Registry() *Registry {
+	return interpreter.registry
+}
+
+// Replace the directives this interpreter understands
+func (interpreter *StandardInterpreter) SetRegistry(registry *Registry) {
+	interpreter.registry = registry
+}
+
+// Run the checks whose caller is not this machine with an executor, such as
+// an AgentExecutor on the caller. Checks that apply to this machine are still
+// run here
+func (interpreter *StandardInterpreter) SetExecutor(caller string, executor Executor) {
+	interpreter.executors[caller] = executor
+}
+
+// Set a variable, which may be used in directives as ${name}. It takes
+// precedence over variables defined in the document and in the environment
+func (interpreter *StandardInterpreter) SetVariable(name string, value string) {
+	interpreter.variables[name] = value
+}
+
+// Set dry run mode, in which Run does not execute the commands, so they are
+// left NOT_RUN. They stay pending, and run at the next Run after dry run mode
+// is turned off. Use it to check the syntax of a document without touching
+// the network
+func (interpreter *StandardInterpreter) SetDryRun(dryRun bool) {
+	interpreter.dryRun = dryRun
+}
+
+// Set the certificates that TLS checks trust, instead of the system's
+func (interpreter *StandardInterpreter) SetRootCAs(roots *x509.CertPool) {
+	interpreter.settings.RootCAs = roots
+}
+
+// Set where the next line of code evaluated is in the document: its line
+// and the column of its first character, both counting from 1. The lines
+// evaluated after it are taken to be on the lines that follow, starting in
+// the same column. Diagnostics give these positions
+func (interpreter *StandardInterpreter) SetPosition(line, column int) {
+	interpreter.line = line - 1
+	interpreter.column = column - 1
+}
+
+// Evaluate a line of Markdown code. If it contains flower directives then record
+// the command so it is executed by the next call to Run. Aliases take effect
+// for the directives that follow them, as do variables, which are substituted
+// before the line is parsed. A line that starts with flower: but is
+// not a valid directive is recorded as a syntax error, and its diagnostic is
+// kept.
 func (interpreter *StandardInterpreter) EvaluateCode(line string) Command {
-	command := Parse(line)
-	if command != nil {
-		interpreter.commands.PushBack(command)
-		command.Execute()
+	interpreter.line++
+	substituted, subs, diagnostic := interpreter.substitute(line)
+	var command Command
+	if diagnostic == nil {
+		command, diagnostic = interpreter.registry.Parse(substituted)
+		if diagnostic != nil && subs != nil {
+			// point at the directive as written
+			diagnostic.Column = subs.original(diagnostic.Column)
+			diagnostic.Directive = line
+		}
+	}
+	if diagnostic != nil {
+		diagnostic.Line = interpreter.line
+		diagnostic.Column += interpreter.column
+		interpreter.diagnostics = append(interpreter.diagnostics, *diagnostic)
+		command = NewSyntaxErrorCommand(*diagnostic)
+	}
+	if command == nil {
+		return nil
+	}
+	if alias, ok := command.(*AliasCommand); ok {
+		interpreter.defineAlias(alias)
+	}
+	if variable, ok := command.(*VariableCommand); ok {
+		interpreter.definitions[variable.name] = variable.value
+	}
+	if resolver, ok := command.(aliasResolver); ok {
+		resolver.resolveAliases(interpreter.aliases)
 	}
+	// the directive is kept as written, so values don't reach the results
+	interpreter.add(command, line, interpreter.referencedVariables(line))
 	return command
 }
 
+// Add an alias to the table. An alias may be defined more than once, but only
+// ever for the same address
+func (interpreter *StandardInterpreter) defineAlias(cmd *AliasCommand) {
+	existing, ok := interpreter.aliases[cmd.alias]
+	if ok && existing != cmd.ip {
+		cmd.err = fmt.Errorf("%s is already an alias for %s", cmd.alias, existing)
+		return
+	}
+	interpreter.aliases[cmd.alias] = cmd.ip
+}
+
+func (interpreter *StandardInterpreter) add(command Command, directive string, variables map[string]string) {
+	entry := &entry{command: command, directive: strings.TrimSpace(directive), variables: variables}
+	if len(interpreter.aliases) > 0 {
+		entry.aliases = make(map[string]string, len(interpreter.aliases))
+		for alias, address := range interpreter.aliases {
+			entry.aliases[alias] = address
+		}
+	}
+	interpreter.commands.PushBack(entry)
+	interpreter.pending = append(interpreter.pending, entry)
+}
+
+// Execute the pending commands, with up to the concurrency limit running at once.
+// Run blocks until every command has finished. Each command holds its own result,
+// so the order in which they finish does not affect the output
+func (interpreter *StandardInterpreter) Run() {
+	if interpreter.dryRun {
+		return
+	}
+	pending := interpreter.pending
+	interpreter.pending = nil
+
+	workers := interpreter.concurrency
+	if workers > len(pending) {
+		workers = len(pending)
+	}
+
+	settings := interpreter.settings
+	queue := make(chan *entry)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for entry := range queue {
+				start := time.Now()
+				entry.command.Execute(&settings)
+				interpreter.executeRemotely(entry, &settings)
+				entry.duration = time.Since(start)
+			}
+		}()
+	}
+	for _, entry := range pending {
+		queue <- entry
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// If a command did not apply to this machine, and there is an executor for
+// its caller, run it there instead
+func (interpreter *StandardInterpreter) executeRemotely(entry *entry, settings *Settings) {
+	command, ok := entry.command.(remoteCommand)
+	if !ok {
+		return
+	}
+	result := entry.command.Report()
+	executor, ok := interpreter.executors[result.Caller]
+	if !ok || result.Match != NO_MATCH {
+		return
+	}
+	// the directive's own timeout and retries say how long the check can
+	// take, so the executor knows how long to wait
+	remoteSettings := *settings
+	remoteSettings.Timeout, remoteSettings.Retries = command.checkSettings(settings)
+	remote, err := executor.Check(entry.directive, entry.aliases, entry.variables, &remoteSettings)
+	if err != nil {
+		remote = Result{Match: MATCH_CALLER, Result: ERROR, Err: err}
+	}
+	command.applyResult(remote, result.Caller)
+}
+
+// Return the outcome of each command, in document order. Variable
+// definitions check nothing, and their values may be secret, so they are
+// left out
+func (interpreter *StandardInterpreter) Results() []Result {
+	results := make([]Result, 0, interpreter.commands.Len())
+	for element := interpreter.commands.Front(); element != nil; element = element.Next() {
+		entry := element.Value.(*entry)
+		if _, ok := entry.command.(*VariableCommand); ok {
+			continue
+		}
+		result := entry.command.Report()
+		result.Directive = entry.directive
+		result.Duration = entry.duration
+		results = append(results, result)
+	}
+	return results
+}
+
+// Return the problems with the directives evaluated, in document order
+func (interpreter *StandardInterpreter) Diagnostics() []Diagnostic {
+	return interpreter.diagnostics
+}
+
+// Set the results of an earlier run, eg: read with ReadJSON, so that the
+// summary report shows what has changed since
+func (interpreter *StandardInterpreter) SetBaseline(results []Result) {
+	interpreter.baseline = results
+}
+
+// Return the differences between the baseline and this run
+func (interpreter *StandardInterpreter) Compare() Diff {
+	return Compare(interpreter.baseline, interpreter.Results())
+}
+
+// Return the outcome of the document as a whole, under a policy
+func (interpreter *StandardInterpreter) Verdict(policy Policy) Verdict {
+	return Evaluate(interpreter.Results(), policy)
+}
 
 // Return a summary of findings
 func (interpreter *StandardInterpreter) SummaryReport() []byte {
@@ -45,9 +367,30 @@ func (interpreter *StandardInterpreter) SummaryReport() []byte {
 
 	fmt.Fprintln(buf, "<tr><th>Rules</th></tr>")
 	for element := interpreter.commands.Front(); element != nil; element = element.Next() {
-		cmd, ok := element.Value.(*ServiceCommand)
+		cmd := element.Value.(*entry).command
+		switch cmd.(type) {
+		case *AliasCommand, *VariableCommand, *SyntaxErrorCommand:
+		default:
+			fmt.Fprintln(buf, "<tr><td class=\""+cmd.HtmlClass()+"\">", html.EscapeString(cmd.String()), "</td></tr>")
+		}
+	}
+
+	if len(interpreter.diagnostics) > 0 {
+		fmt.Fprintln(buf, "<tr><th>Diagnostics</th></tr>")
+		for _, diagnostic := range interpreter.diagnostics {
+			fmt.Fprintln(buf, "<tr><td class=\"FLOWER-SYNTAX FLOWER-ERROR\">", html.EscapeString(diagnostic.String()+": "+strings.TrimSpace(diagnostic.Directive)), "</td></tr>")
+		}
+	}
+
+	if interpreter.baseline != nil {
+		interpreter.Compare().writeHtml(buf)
+	}
+
+	fmt.Fprintln(buf, "<tr><th>Aliases</th></tr>")
+	for element := interpreter.commands.Front(); element != nil; element = element.Next() {
+		cmd, ok := element.Value.(*entry).command.(*AliasCommand)
 		if ok {
-			fmt.Fprintln(buf, "<tr><td>", cmd.String(), "</tr></td>")
+			fmt.Fprintln(buf, "<tr><td class=\""+cmd.HtmlClass()+"\">", html.EscapeString(cmd.String()), "</td></tr>")
 		}
 	}
 	fmt.Fprintln(buf, "</table>")
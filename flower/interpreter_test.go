@@ -0,0 +1,162 @@
+package flower
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A command that records how many commands are executing alongside it
+type countingCommand struct {
+	id       int
+	executed bool
+	running  *int
+	maximum  *int
+	lock     *sync.Mutex
+}
+
+func (cmd *countingCommand) Execute(settings *Settings) {
+	cmd.lock.Lock()
+	*cmd.running++
+	if *cmd.running > *cmd.maximum {
+		*cmd.maximum = *cmd.running
+	}
+	cmd.lock.Unlock()
+
+	// later commands finish first
+	time.Sleep(time.Duration(20-cmd.id) * time.Millisecond)
+
+	cmd.lock.Lock()
+	*cmd.running--
+	cmd.executed = true
+	cmd.lock.Unlock()
+}
+
+func (cmd *countingCommand) HtmlClass() string {
+	return ""
+}
+
+func (cmd *countingCommand) String() string {
+	return "command:" + strconv.Itoa(cmd.id)
+}
+
+func (cmd *countingCommand) Report() Result {
+	return Result{Host: strconv.Itoa(cmd.id), Result: OK}
+}
+
+func Test_Run_Concurrency(t *testing.T) {
+	var running, maximum int
+	var lock sync.Mutex
+
+	interpreter := NewInterpreter()
+	interpreter.SetConcurrency(3)
+	for i := 0; i < 20; i++ {
+		interpreter.add(&countingCommand{id: i, running: &running, maximum: &maximum, lock: &lock}, "", nil)
+	}
+	interpreter.Run()
+
+	if maximum > 3 {
+		t.Errorf("Expected at most 3 commands running at once, got %d", maximum)
+	}
+	if maximum < 2 {
+		t.Errorf("Expected commands to run concurrently, got %d at once", maximum)
+	}
+
+	i := 0
+	for element := interpreter.commands.Front(); element != nil; element = element.Next() {
+		cmd := element.Value.(*entry).command.(*countingCommand)
+		if cmd.id != i {
+			t.Errorf("Commands out of order. Expected %d, got %d", i, cmd.id)
+		}
+		if !cmd.executed {
+			t.Errorf("Command %d was not executed", cmd.id)
+		}
+		i++
+	}
+}
+
+func Test_Run_OnlyPending(t *testing.T) {
+	var running, maximum int
+	var lock sync.Mutex
+
+	interpreter := NewInterpreter()
+	first := &countingCommand{id: 0, running: &running, maximum: &maximum, lock: &lock}
+	interpreter.add(first, "", nil)
+	interpreter.Run()
+
+	first.executed = false
+	second := &countingCommand{id: 1, running: &running, maximum: &maximum, lock: &lock}
+	interpreter.add(second, "", nil)
+	interpreter.Run()
+
+	if first.executed {
+		t.Errorf("Command %d was executed twice", first.id)
+	}
+	if !second.executed {
+		t.Errorf("Command %d was not executed", second.id)
+	}
+}
+
+func Test_Run_DryRun(t *testing.T) {
+	prober := testProber()
+	prober.Open("tcp", "10.0.0.2:80")
+
+	interpreter := NewInterpreter()
+	interpreter.SetProber(prober)
+	interpreter.SetDryRun(true)
+	cmd := interpreter.EvaluateCode("flower: here offers http")
+	interpreter.Run()
+
+	result := cmd.Report()
+	if result.Result != NOT_RUN || result.Match != NO_MATCH {
+		t.Errorf("Expected NOT_RUN, got %s %s", ResultName(result.Result), MatchName(result.Match))
+	}
+	if cmd.HtmlClass() != "FLOWER-NO-MATCH FLOWER-NOT-RUN" {
+		t.Errorf("Unexpected class %s", cmd.HtmlClass())
+	}
+	if !interpreter.Verdict(DefaultPolicy).Passed {
+		t.Errorf("Expected a dry run to pass")
+	}
+
+	// the dry run command is still pending, and runs with the next one
+	interpreter.SetDryRun(false)
+	interpreter.EvaluateCode("flower: here offers http")
+	interpreter.Run()
+	for i, result := range interpreter.Results() {
+		if result.Result != OK {
+			t.Errorf("Command %d, expected OK, got %s", i, ResultName(result.Result))
+		}
+	}
+}
+
+func Test_EvaluateCode_SyntaxError(t *testing.T) {
+	tests := map[string]bool{
+		"flower: here offers":             true,
+		"  flower: here serves http":      true,
+		"flower: here offers http:99999x": true,
+		"flower: here offers http":        false,
+		"not a flower: directive":         false,
+		"":                                false,
+	}
+	for input, syntaxError := range tests {
+		interpreter := NewInterpreter()
+		interpreter.SetDryRun(true)
+		cmd := interpreter.EvaluateCode(input)
+		_, ok := cmd.(*SyntaxErrorCommand)
+		if ok != syntaxError {
+			t.Errorf("Input %s, expected syntax error %v, got %v", input, syntaxError, cmd)
+			continue
+		}
+		if syntaxError {
+			interpreter.Run()
+			verdict := interpreter.Verdict(DefaultPolicy)
+			if verdict.Passed || verdict.Count(ERROR) != 1 {
+				t.Errorf("Input %s, expected the syntax error to fail, got %+v", input, verdict)
+			}
+			if cmd.HtmlClass() != "FLOWER-SYNTAX FLOWER-ERROR" {
+				t.Errorf("Input %s, unexpected class %s", input, cmd.HtmlClass())
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package flower
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metrics collects the results of runs of flower checks, and writes them in
+// the Prometheus text exposition format:
+//
+//	flower_check_up               1 if the check was OK on its last run, otherwise 0
+//	flower_check_duration_seconds how long the last run of the check took
+//	flower_check_errors_total     the number of runs of the check that were not OK
+//
+// Each is labelled with the check's host, service, port and caller. Checks
+// that do not apply to this host, and those not run, are left out. Metrics is
+// safe to use from many goroutines, and serves the metrics over HTTP
+type Metrics struct {
+	lock   sync.Mutex
+	checks map[metricLabels]*metric
+
+	// The checks in the last run, in document order
+	current []metricLabels
+}
+
+type metricLabels struct {
+	host    string
+	service string
+	port    int
+	caller  string
+}
+
+type metric struct {
+	up       int
+	duration float64
+	errors   int
+}
+
+// Create a Metrics with no checks
+func NewMetrics() *Metrics {
+	return &Metrics{checks: make(map[metricLabels]*metric)}
+}
+
+// Add the results of a run of the checks, eg: interpreter.Results(). The
+// metrics then describe the checks in this run
+func (metrics *Metrics) Observe(results []Result) {
+	metrics.lock.Lock()
+	defer metrics.lock.Unlock()
+	metrics.current = nil
+	seen := make(map[metricLabels]bool)
+	for _, result := range results {
+		if result.Caller == "" || result.Host == "" || result.Result == NOT_RUN {
+			continue
+		}
+		if result.Match == NO_MATCH && result.Result == NO_ANSWER {
+			continue
+		}
+		labels := metricLabels{result.Host, result.Service, result.Port, result.Caller}
+		check, ok := metrics.checks[labels]
+		if !ok {
+			check = new(metric)
+			metrics.checks[labels] = check
+		}
+		if !seen[labels] {
+			seen[labels] = true
+			metrics.current = append(metrics.current, labels)
+		}
+		check.up = 0
+		if result.Result == OK && result.Err == nil {
+			check.up = 1
+		} else {
+			check.errors++
+		}
+		check.duration = result.Duration.Seconds()
+	}
+}
+
+// Write the metrics in the Prometheus text exposition format
+func (metrics *Metrics) Write(w io.Writer) error {
+	metrics.lock.Lock()
+	defer metrics.lock.Unlock()
+	var buf bytes.Buffer
+
+	buf.WriteString("# HELP flower_check_up Whether the check was OK on its last run.\n")
+	buf.WriteString("# TYPE flower_check_up gauge\n")
+	for _, labels := range metrics.current {
+		fmt.Fprintf(&buf, "flower_check_up%s %d\n", labels, metrics.checks[labels].up)
+	}
+
+	buf.WriteString("# HELP flower_check_duration_seconds How long the last run of the check took.\n")
+	buf.WriteString("# TYPE flower_check_duration_seconds gauge\n")
+	for _, labels := range metrics.current {
+		fmt.Fprintf(&buf, "flower_check_duration_seconds%s %s\n", labels, strconv.FormatFloat(metrics.checks[labels].duration, 'g', -1, 64))
+	}
+
+	buf.WriteString("# HELP flower_check_errors_total The number of runs of the check that were not OK.\n")
+	buf.WriteString("# TYPE flower_check_errors_total counter\n")
+	for _, labels := range metrics.current {
+		fmt.Fprintf(&buf, "flower_check_errors_total%s %d\n", labels, metrics.checks[labels].errors)
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func (metrics *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.Write(w)
+}
+
+// Return the labels in the exposition format, eg: {host="db",...}
+func (labels metricLabels) String() string {
+	return `{host="` + escapeLabel(labels.host) +
+		`",service="` + escapeLabel(labels.service) +
+		`",port="` + strconv.Itoa(labels.port) +
+		`",caller="` + escapeLabel(labels.caller) + `"}`
+}
+
+// Escape a label value for the exposition format
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,83 @@
+package flower
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Metrics(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.Observe(reportInterpreter().Results())
+
+	var buf bytes.Buffer
+	if err := metrics.Write(&buf); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	for _, expected := range []string{
+		"# TYPE flower_check_up gauge\n",
+		`flower_check_up{host="here",service="http",port="80",caller="here"} 1` + "\n",
+		`flower_check_up{host="here",service="http",port="81",caller="here"} 0` + "\n",
+		`flower_check_up{host="nowhere",service="http",port="80",caller="nowhere"} 0` + "\n",
+		"# TYPE flower_check_duration_seconds gauge\n",
+		"# TYPE flower_check_errors_total counter\n",
+		`flower_check_errors_total{host="here",service="http",port="8080",caller="here"} 1` + "\n",
+		`flower_check_errors_total{host="here",service="http",port="80",caller="here"} 0` + "\n",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Expected %s in\n%s", expected, buf.String())
+		}
+	}
+	// www.gogle.com does not apply to this host
+	if strings.Contains(buf.String(), "gogle") {
+		t.Errorf("Unexpected check that does not apply in\n%s", buf.String())
+	}
+}
+
+func Test_Metrics_Observe(t *testing.T) {
+	metrics := NewMetrics()
+	ok := Result{Host: "db", Service: "postgres", Port: 5432, Caller: "web", Match: MATCH_CALLER, Result: OK, Duration: 250 * time.Millisecond}
+	failed := ok
+	failed.Result = FAIL
+	other := Result{Host: "a\"b", Service: "redis", Port: 6379, Caller: "web", Match: MATCH_CALLER, Result: TIMEOUT}
+
+	metrics.Observe([]Result{failed, other})
+	metrics.Observe([]Result{ok})
+	metrics.Observe([]Result{failed})
+
+	server := httptest.NewServer(metrics)
+	defer server.Close()
+	response, err := server.Client().Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+
+	expected := `# HELP flower_check_up Whether the check was OK on its last run.
+# TYPE flower_check_up gauge
+flower_check_up{host="db",service="postgres",port="5432",caller="web"} 0
+# HELP flower_check_duration_seconds How long the last run of the check took.
+# TYPE flower_check_duration_seconds gauge
+flower_check_duration_seconds{host="db",service="postgres",port="5432",caller="web"} 0.25
+# HELP flower_check_errors_total The number of runs of the check that were not OK.
+# TYPE flower_check_errors_total counter
+flower_check_errors_total{host="db",service="postgres",port="5432",caller="web"} 2
+`
+	if string(body) != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, body)
+	}
+	if !strings.HasPrefix(response.Header.Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("Unexpected content type %s", response.Header.Get("Content-Type"))
+	}
+
+	metrics.Observe([]Result{other})
+	var buf bytes.Buffer
+	metrics.Write(&buf)
+	if !strings.Contains(buf.String(), `flower_check_errors_total{host="a\"b",service="redis",port="6379",caller="web"} 2`) {
+		t.Errorf("Expected the label to be escaped, and the count kept, in\n%s", buf.String())
+	}
+}
@@ -4,75 +4,207 @@ import (
 	//"fmt"
 	"regexp"
 	"strconv"
+	"strings"
+	"time"
 )
 
-// Mappings from Services -> default port
+// Mappings from Services -> default port. These are the services a Catalogue
+// made by NewDefaultCatalogue starts with; NewCatalogue starts empty
 var ServicePort = map[string]int{
-	"http": 80,
-	"smtp": 25,
+	"ftp":           21,
+	"ssh":           22,
+	"telnet":        23,
+	"smtp":          25,
+	"dns":           53,
+	"http":          80,
+	"kerberos":      88,
+	"pop3":          110,
+	"ntp":           123,
+	"imap":          143,
+	"snmp":          161,
+	"ldap":          389,
+	"https":         443,
+	"smtps":         465,
+	"syslog":        514,
+	"submission":    587,
+	"ldaps":         636,
+	"imaps":         993,
+	"pop3s":         995,
+	"mssql":         1433,
+	"oracle":        1521,
+	"nfs":           2049,
+	"zookeeper":     2181,
+	"etcd":          2379,
+	"mysql":         3306,
+	"rdp":           3389,
+	"postgres":      5432,
+	"amqp":          5672,
+	"amqps":         5671,
+	"vnc":           5900,
+	"redis":         6379,
+	"statsd":        8125,
+	"vault":         8200,
+	"consul":        8500,
+	"kafka":         9092,
+	"prometheus":    9090,
+	"elasticsearch": 9200,
+	"memcached":     11211,
+	"mongodb":       27017,
 }
 
-// Map from command name to a regular expression, that contains named
-// subexpressions (?P<name>regex) that represent the arguments to the command
-// that must be captured
-var CommandRegex = map[string]*regexp.Regexp{
-	// <ip> is <alias
-	//"host_alias": regexp.MustCompile(`^flower:\s*(?P<ip>[0-9\.\*]+)\s*is\s*(?P<host>\w+)\s*$`),
+// Mappings from Services -> protocol used to check them, when not tcp.
+// 'udp' may also be used as a service name with an explicit port
+var ServiceProtocol = map[string]string{
+	"dns":    "dns",
+	"ntp":    "udp",
+	"snmp":   "udp",
+	"syslog": "udp",
+	"statsd": "udp",
+	"udp":    "udp",
+}
+
+// Options that may follow any directive that performs a network check, in
+// either order eg: 'timeout 2s retries 3' or 'retries 3 timeout 2s'. Each
+// name appears twice; only the alternative that matched captures anything
+const checkOptions = `(\s+timeout\s+(?P<timeout>\S+)(\s+retries\s+(?P<retries>\d+))?|\s+retries\s+(?P<retries>\d+)(\s+timeout\s+(?P<timeout>\S+))?)?`
+
+// The built in commands, shared by Parse and ParseDirective. Interpreters have
+// their own copy, see NewDefaultRegistry
+var builtins = NewDefaultRegistry()
+
+// Register the built in commands. Each has a regular expression that contains
+// named subexpressions (?P<name>regex) that represent the arguments to the
+// command that must be captured
+func registerBuiltins(registry *Registry) {
+	// <ip> is <alias>
+	registry.MustRegister("host_alias", regexp.MustCompile(`^\s*flower:\s*(?P<ip>[0-9\.\*]+)\s+is\s+(?P<host>[\w\.\-]+)\s*$`), buildHostAlias)
+	// set <name> = <value>
+	registry.MustRegister("set_variable", regexp.MustCompile(`^\s*flower:\s*set\s+(?P<name>[A-Za-z_][\w\.\-]*)\s*=\s*(?P<value>\S.*?)\s*$`), buildVariable)
 	// <alias> offers <service>:port
-	"local_service": regexp.MustCompile(`^flower:\s*(?P<host>\S+)\s*offers\s*(?P<service>\w+)(:(?P<port>\d+))?\s*$`),
+	registry.MustRegister("local_service", regexp.MustCompile(`^\s*flower:\s*(?P<host>\S+)\s*offers\s*(?P<service>\w+)(:(?P<port>\d+))?`+checkOptions+`\s*$`), buildLocalService)
+	// <alias> offers http(s):port path <path> expect <status> | body "<text>"
+	registry.MustRegister("http_check", regexp.MustCompile(`^\s*flower:\s*(?P<host>\S+)\s+offers\s+(?P<service>https?)(:(?P<port>\d+))?\s+path\s+(?P<path>/\S*)\s+expect\s+((?P<status>\d{3})|body\s+"(?P<body>[^"]*)")`+checkOptions+`\s*$`), buildHttpCheck)
+	// <alias> offers <service>:port cert-valid-for <days> cert-cn <name> ca <file>
+	// at least one of the cert- options must be present
+	registry.MustRegister("tls_check", regexp.MustCompile(`^\s*flower:\s*(?P<host>\S+)\s+offers\s+(?P<service>\w+)(:(?P<port>\d+))?(?P<certoptions>(\s+(cert-valid-for|cert-cn|ca)\s+\S+)*\s+cert-(valid-for|cn)\s+\S+(\s+(cert-valid-for|cert-cn|ca)\s+\S+)*)`+checkOptions+`\s*$`), buildTlsCheck)
 	// <alias> uses <service>:port at <alias>
-	"remote_service": regexp.MustCompile(`^flower:\s*(?P<local>\S+)\s*uses\s*(?P<service>\w+)(:(?P<port>\d+))?\s+(at)?\s+(?P<remote>\S+)\s*$`),
+	registry.MustRegister("remote_service", regexp.MustCompile(`^\s*flower:\s*(?P<local>\S+)\s*uses\s*(?P<service>\w+)(:(?P<port>\d+))?\s+(at)?\s+(?P<remote>\S+)`+checkOptions+`\s*$`), buildRemoteService)
 }
 
-// Parse a string, find a matching command, or nil
+// Map from the name of each built in command to the regular expression it
+// is matched with.
+//
+// Deprecated: commands are kept in a Registry, see NewDefaultRegistry and
+// StandardInterpreter.Registry. Changing this map has no effect.
+var CommandRegex = builtins.patterns()
+
+// Build the built in command with the given name from the arguments captured
+// by its regular expression, or return nil if the arguments are invalid.
+//
+// Deprecated: use a Registry, whose Parse builds commands from directives.
+func BuildCommand(command string, params map[string]string) Command {
+	return builtins.build(command, params)
+}
+
+// Parse a string, find a matching built in command, or nil
 func Parse(line string) Command {
-	//fmt.Println("Parsing: " + line)
-	for command, regex := range CommandRegex {
-	//fmt.Print("Matches " + command + " : ")
-		//fmt.Printf("Is a  %s ? %t\n",command, regex.MatchString(line))
-		if regex.MatchString(line) {
-			//fmt.Println("Yes")
-			// Turn parenthesized sub expressions into a map[string]string
-			params := make(map[string]string)
-			for _, key := range regex.SubexpNames() {
-				params[key] = regex.ReplaceAllString(line, "${"+key+"}")
-			}
-			//fmt.Printf("Got params %q\n", params)
-			return BuildCommand(command, params)
-		} else {
-			//fmt.Println("No")
-		}
-	}
-	return nil
+	command, _ := builtins.Parse(line)
+	return command
 }
 
-// Build a ParsedCommand
-func BuildCommand(command string, params map[string]string) Command {
-	switch {
-	case command == "local_service":
-		var port int
+// Parse a string, find a matching built in command. If the line starts with
+// flower: but is not a valid directive return nil and a diagnostic saying why
+func ParseDirective(line string) (Command, *Diagnostic) {
+	return builtins.Parse(line)
+}
+
+func buildHostAlias(params map[string]string) Command {
+	return NewAliasCommand(params["ip"], params["host"])
+}
+
+func buildLocalService(params map[string]string) Command {
+	cmd := NewServiceCommand(params["host"], params["service"], servicePort(params), params["host"])
+	cmd.protocol = serviceProtocol(params)
+	return withCheckOptions(cmd, params)
+}
+
+func buildHttpCheck(params map[string]string) Command {
+	var status int
+	if params["status"] != "" {
 		var err error
-		if params["port"] == "" {
-			port = ServicePort[params["service"]]
-		} else {
-			// check port is a int
-			port, err = strconv.Atoi(params["port"])
+		if status, err = strconv.Atoi(params["status"]); err != nil {
+			return nil
 		}
-		if err == nil {
-			return NewServiceCommand(params["host"],params["service"], port, params["host"])
+	}
+	cmd := NewHttpCommand(params["host"], params["service"], servicePort(params), params["path"], status, params["body"])
+	if withCheckOptions(&cmd.ServiceCommand, params) == nil {
+		return nil
+	}
+	return cmd
+}
+
+func buildTlsCheck(params map[string]string) Command {
+	var validFor time.Duration
+	var name, caFile string
+	options := strings.Fields(params["certoptions"])
+	for i := 0; i+1 < len(options); i += 2 {
+		switch options[i] {
+		case "cert-valid-for":
+			var err error
+			if validFor, err = ParseValidity(options[i+1]); err != nil {
+				return nil
+			}
+		case "cert-cn":
+			name = options[i+1]
+		case "ca":
+			caFile = options[i+1]
 		}
-	case command == "remote_service":
-		var port int
-		var err error
-		if params["port"] == "" {
-			port = ServicePort[params["service"]]
-		} else {
-			// check port is a int
-			port, err = strconv.Atoi(params["port"])
+	}
+	cmd := NewTlsCommand(params["host"], params["service"], servicePort(params), validFor, name, caFile)
+	if withCheckOptions(&cmd.ServiceCommand, params) == nil {
+		return nil
+	}
+	return cmd
+}
+
+func buildRemoteService(params map[string]string) Command {
+	cmd := NewServiceCommand(params["remote"], params["service"], servicePort(params), params["local"])
+	cmd.protocol = serviceProtocol(params)
+	cmd.uses = true
+	return withCheckOptions(cmd, params)
+}
+
+// Return the port given in a directive. When the directive names a service
+// without a port, the registry fills in the port from its catalogue
+func servicePort(params map[string]string) int {
+	port, _ := strconv.Atoi(params["port"])
+	return port
+}
+
+// Return the protocol used to check a service. The registry fills it in from
+// its catalogue
+func serviceProtocol(params map[string]string) string {
+	if params["protocol"] != "" {
+		return params["protocol"]
+	}
+	return "tcp"
+}
+
+// Apply the timeout and retries options to a command, returning nil if they are invalid
+func withCheckOptions(cmd *ServiceCommand, params map[string]string) Command {
+	if params["timeout"] != "" {
+		timeout, err := time.ParseDuration(params["timeout"])
+		if err != nil || timeout <= 0 {
+			return nil
 		}
-		if err == nil {
-			return NewServiceCommand(params["remote"],params["service"], port, params["local"])
+		cmd.timeout = timeout
+	}
+	if params["retries"] != "" {
+		retries, err := strconv.Atoi(params["retries"])
+		if err != nil || retries > MAX_RETRIES {
+			return nil
 		}
+		cmd.retries = retries
 	}
-	return nil
+	return cmd
 }
@@ -1,7 +1,9 @@
 package flower
 
 import (
+	"strings"
 	"testing"
+	"time"
 )
 
 func Test_OffersService(t *testing.T) {
@@ -26,6 +28,50 @@ func Test_UsesService(t *testing.T) {
 	AssertEquals(ServiceCommand{host: "my.remote.host", service: "http", port: 8080, caller: "my.host.name"}, input, t)
 }
 
+func Test_HostAlias(t *testing.T) {
+	inputs := map[string]AliasCommand{
+		"flower: 10.0.0.5 is db1":       {ip: "10.0.0.5", alias: "db1"},
+		"flower: 10.0.* is web-servers": {ip: "10.0.*", alias: "web-servers"},
+	}
+	for input, expected := range inputs {
+		actual, ok := Parse(input).(*AliasCommand)
+		if !ok {
+			t.Errorf("Expected an alias. Input %s", input)
+			continue
+		}
+		if actual.ip != expected.ip || actual.alias != expected.alias {
+			t.Errorf("Alias mismatch. Expected %s, got %s. Input %s", expected.String(), actual, input)
+		}
+	}
+}
+
+func Test_CheckOptions(t *testing.T) {
+	input := "flower: HOST1 offers http timeout 2s retries 3"
+	AssertEquals(ServiceCommand{host: "HOST1", service: "http", port: 80, caller: "HOST1"}, input, t)
+	cmd := Parse(input).(*ServiceCommand)
+	if cmd.timeout != 2*time.Second {
+		t.Errorf("Timeout mismatch. Expected 2s, got %v. Input %s", cmd.timeout, input)
+	}
+	if cmd.retries != 3 {
+		t.Errorf("Retries mismatch. Expected 3, got %d. Input %s", cmd.retries, input)
+	}
+
+	input = "flower: HOST1 uses http:8080 at HOST2 timeout 500ms"
+	AssertEquals(ServiceCommand{host: "HOST2", service: "http", port: 8080, caller: "HOST1"}, input, t)
+	cmd = Parse(input).(*ServiceCommand)
+	if cmd.timeout != 500*time.Millisecond {
+		t.Errorf("Timeout mismatch. Expected 500ms, got %v. Input %s", cmd.timeout, input)
+	}
+	if cmd.retries != -1 {
+		t.Errorf("Retries mismatch. Expected -1, got %d. Input %s", cmd.retries, input)
+	}
+
+	input = "flower: HOST1 offers http timeout soon"
+	if Parse(input) != nil {
+		t.Errorf("Expected no command for invalid timeout. Input %s", input)
+	}
+}
+
 func AssertEquals(expected ServiceCommand, input string, t *testing.T) {
 	actual := Parse(input)
 	a := actual.(*ServiceCommand)
@@ -33,7 +79,7 @@ func AssertEquals(expected ServiceCommand, input string, t *testing.T) {
 		t.Errorf("Host mismatch. Expected %s, got %s. Input %s", expected.host, a.host, input)
 	}
 	if expected.port != a.port {
-		t.Errorf("Port mismatch. Expected %s, got %s. Input %s", expected.port, a.port, input)
+		t.Errorf("Port mismatch. Expected %d, got %d. Input %s", expected.port, a.port, input)
 	}
 	if expected.service != a.service {
 		t.Errorf("Service mismatch. Expected %s, got %s. Input %s", expected.service, a.service, input)
@@ -42,3 +88,55 @@ func AssertEquals(expected ServiceCommand, input string, t *testing.T) {
 		t.Errorf("Caller mismatch. Expected %s, got %s. Input %s", expected.caller, a.caller, input)
 	}
 }
+
+func Test_CheckOptionsEitherOrder(t *testing.T) {
+	input := "flower: HOST1 uses ssh at HOST2 retries 2 timeout 1s"
+	AssertEquals(ServiceCommand{host: "HOST2", service: "ssh", port: 22, caller: "HOST1"}, input, t)
+	cmd := Parse(input).(*ServiceCommand)
+	if cmd.timeout != time.Second || cmd.retries != 2 {
+		t.Errorf("Options mismatch. Expected 1s and 2, got %v and %d. Input %s", cmd.timeout, cmd.retries, input)
+	}
+
+	input = "flower: HOST1 offers http retries 1"
+	if cmd := Parse(input).(*ServiceCommand); cmd.retries != 1 || cmd.timeout != 0 {
+		t.Errorf("Options mismatch. Expected no timeout and 1, got %v and %d. Input %s", cmd.timeout, cmd.retries, input)
+	}
+}
+
+func Test_TooManyRetries(t *testing.T) {
+	input := "flower: HOST1 offers http timeout 5s retries 99999"
+	cmd, diagnostic := ParseDirective(input)
+	if cmd != nil || diagnostic == nil {
+		t.Fatalf("Expected a diagnostic, got %v. Input %s", cmd, input)
+	}
+	if diagnostic.Reason != "more than 10 retries" || diagnostic.Column != strings.Index(input, "99999")+1 {
+		t.Errorf("Diagnostic mismatch, got %s. Input %s", diagnostic, input)
+	}
+
+	// settings that didn't come from a directive are capped too
+	settings := Settings{Retries: 99999}
+	if _, retries := NewServiceCommand("HOST1", "http", 80, "HOST1").checkSettings(&settings); retries != MAX_RETRIES {
+		t.Errorf("Expected retries capped at %d, got %d", MAX_RETRIES, retries)
+	}
+}
+
+func Test_DeprecatedWrappers(t *testing.T) {
+	if !CommandRegex["remote_service"].MatchString("flower: web uses ssh at db") {
+		t.Errorf("Expected the remote_service pattern to match")
+	}
+
+	params := map[string]string{"local": "web", "service": "ssh", "remote": "db"}
+	cmd, ok := BuildCommand("remote_service", params).(*ServiceCommand)
+	if !ok || cmd.host != "db" || cmd.caller != "web" || cmd.port != 22 || cmd.protocol != "tcp" {
+		t.Errorf("Unexpected command %v", cmd)
+	}
+	if _, ok := params["port"]; ok {
+		t.Errorf("BuildCommand changed its arguments %v", params)
+	}
+	if cmd := BuildCommand("remote_service", map[string]string{"local": "web", "service": "ssh", "port": "99999", "remote": "db"}); cmd != nil {
+		t.Errorf("Expected nil for an invalid port, got %v", cmd)
+	}
+	if cmd := BuildCommand("no_such_command", params); cmd != nil {
+		t.Errorf("Expected nil for an unknown command, got %v", cmd)
+	}
+}
@@ -0,0 +1,112 @@
+package flower
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+)
+
+// A probe checks whether a service answers at an address, and returns OK, FAIL
+// or TIMEOUT
+type probe func(prober Prober, address string, timeout time.Duration) int
+
+// The probe used for each protocol
+var protocolProbes = map[string]probe{
+	"tcp": ScanPort,
+	"udp": ProbeUDP,
+	"dns": ProbeDNS,
+}
+
+// Try to connect to a TCP address, returning OK if the port is open, FAIL if
+// the connection is refused, or TIMEOUT if there is no answer in time
+func ScanPort(prober Prober, address string, timeout time.Duration) int {
+	conn, err := prober.Dial("tcp", address, timeout)
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return TIMEOUT
+		}
+		return FAIL
+	}
+	conn.Close()
+	return OK
+}
+
+// Send an empty datagram to a UDP address, returning OK if anything comes
+// back, FAIL if it is refused, or TIMEOUT if there is no answer in time. Most
+// UDP services do not answer, and a filtered port is silent too, so TIMEOUT
+// means the port is open or filtered, but not which
+func ProbeUDP(prober Prober, address string, timeout time.Duration) int {
+	conn, err := prober.Dial("udp", address, timeout)
+	if err != nil {
+		return FAIL
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write([]byte{}); err != nil {
+		return FAIL
+	}
+	buf := make([]byte, 512)
+	if _, err := conn.Read(buf); err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return TIMEOUT
+		}
+		return FAIL
+	}
+	return OK
+}
+
+// The ID of the queries sent by ProbeDNS
+const dnsQueryId = 0x464c
+
+// Send a DNS query for the root name servers to a UDP address, returning OK if
+// a reply comes back, whatever it says, FAIL if the query is refused, or
+// TIMEOUT if there is no reply in time
+func ProbeDNS(prober Prober, address string, timeout time.Duration) int {
+	conn, err := prober.Dial("udp", address, timeout)
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return TIMEOUT
+		}
+		return FAIL
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write(DnsQuery(dnsQueryId)); err != nil {
+		return FAIL
+	}
+	buf := make([]byte, 512)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				return TIMEOUT
+			}
+			return FAIL
+		}
+		if IsDnsReply(buf[:n], dnsQueryId) {
+			return OK
+		}
+		// not an answer to our query, keep listening
+	}
+}
+
+// Return a DNS query, with recursion desired, for the NS records of the root
+func DnsQuery(id uint16) []byte {
+	query := make([]byte, 17)
+	binary.BigEndian.PutUint16(query[0:], id)
+	binary.BigEndian.PutUint16(query[2:], 0x0100) // standard query, recursion desired
+	binary.BigEndian.PutUint16(query[4:], 1)      // one question
+	query[12] = 0                                 // the root name
+	binary.BigEndian.PutUint16(query[13:], 2)     // type NS
+	binary.BigEndian.PutUint16(query[15:], 1)     // class IN
+	return query
+}
+
+// Return true if a message is a DNS reply to the query with the given ID
+func IsDnsReply(message []byte, id uint16) bool {
+	return len(message) >= 12 &&
+		binary.BigEndian.Uint16(message[0:]) == id &&
+		message[2]&0x80 != 0
+}
@@ -0,0 +1,111 @@
+package flower
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// Answer DNS queries on a local UDP port until it is closed
+func serveDNS(t *testing.T) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket returned error: %v", err)
+	}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < 12 {
+				continue
+			}
+			reply := append([]byte{}, buf[:n]...)
+			reply[2] |= 0x80
+			conn.WriteTo(reply, from)
+		}
+	}()
+	return conn
+}
+
+func Test_ProbeDNS(t *testing.T) {
+	server := serveDNS(t)
+	defer server.Close()
+
+	result := ProbeDNS(NetProber{}, server.LocalAddr().String(), time.Second)
+	if result != OK {
+		t.Errorf("ProbeDNS('%s') should return OK, got %d", server.LocalAddr(), result)
+	}
+}
+
+func Test_ProbeDNS_Silent(t *testing.T) {
+	prober := testProber()
+	prober.Open("udp", "10.0.0.2:53")
+
+	result := ProbeDNS(prober, "10.0.0.2:53", 50*time.Millisecond)
+	if result != TIMEOUT {
+		t.Errorf("ProbeDNS should return TIMEOUT when there is no reply, got %d", result)
+	}
+}
+
+func Test_ProbeUDP(t *testing.T) {
+	server := serveDNS(t)
+	address := server.LocalAddr().String()
+
+	// the server ignores empty datagrams, as a filtered port would
+	result := ProbeUDP(NetProber{}, address, 100*time.Millisecond)
+	if result != TIMEOUT {
+		t.Errorf("ProbeUDP('%s') should return TIMEOUT when nothing answers, got %d", address, result)
+	}
+
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket returned error: %v", err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 512)
+		if _, from, err := echo.ReadFrom(buf); err == nil {
+			echo.WriteTo([]byte("hello"), from)
+		}
+	}()
+	result = ProbeUDP(NetProber{}, echo.LocalAddr().String(), time.Second)
+	if result != OK {
+		t.Errorf("ProbeUDP('%s') should return OK when answered, got %d", echo.LocalAddr(), result)
+	}
+
+	server.Close()
+	result = ProbeUDP(NetProber{}, address, time.Second)
+	if result != FAIL {
+		t.Errorf("ProbeUDP('%s') should return FAIL once closed, got %d", address, result)
+	}
+}
+
+func Test_UdpServices(t *testing.T) {
+	cmd := Parse("flower: ns1 offers dns").(*ServiceCommand)
+	if cmd.port != 53 || cmd.protocol != "dns" {
+		t.Errorf("Unexpected command %s", cmd)
+	}
+	cmd = Parse("flower: web1 uses udp:514 at logs").(*ServiceCommand)
+	if cmd.port != 514 || cmd.protocol != "udp" || cmd.host != "logs" {
+		t.Errorf("Unexpected command %s", cmd)
+	}
+	cmd = Parse("flower: web1 uses statsd at metrics").(*ServiceCommand)
+	if cmd.port != 8125 || cmd.protocol != "udp" {
+		t.Errorf("Unexpected command %s", cmd)
+	}
+	cmd = Parse("flower: web1 offers http").(*ServiceCommand)
+	if cmd.protocol != "tcp" {
+		t.Errorf("Unexpected command %s", cmd)
+	}
+
+	prober := testProber()
+	prober.Open("udp", "142.250.0.1:514")
+	cmd = Parse("flower: here uses udp:514 at www.gogle.com timeout 50ms").(*ServiceCommand)
+	cmd.Execute(&Settings{Prober: prober})
+	if cmd.match != MATCH_CALLER || cmd.result != TIMEOUT {
+		t.Errorf("Expected a silent UDP port to time out, got %s", cmd)
+	}
+}
@@ -0,0 +1,152 @@
+package flower
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+)
+
+// Resolver finds the addresses of hosts, and of the machine it is running on
+type Resolver interface {
+	// Return the IP addresses of a host
+	LookupIP(host string) ([]net.IP, error)
+
+	// Return the IP addresses of this machine
+	LocalIPs() ([]net.IP, error)
+}
+
+// Prober is used by commands to reach the network
+type Prober interface {
+	Resolver
+
+	// Connect to an address on the named network, eg: "tcp", "udp"
+	Dial(network, address string, timeout time.Duration) (net.Conn, error)
+}
+
+// NetProber is a Prober that uses the real network, via the net package
+type NetProber struct{}
+
+func (prober NetProber) LookupIP(host string) ([]net.IP, error) {
+	return net.LookupIP(host)
+}
+
+func (prober NetProber) LocalIPs() ([]net.IP, error) {
+	addr, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, 0, len(addr))
+	for _, address := range addr {
+		ip, _, err := net.ParseCIDR(address.String())
+		if err != nil {
+			return nil, err
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+func (prober NetProber) Dial(network, address string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout(network, address, timeout)
+}
+
+// ScriptedProber is a Prober that answers from tables set up in advance,
+// instead of touching the network. Use it to check documents offline.
+//
+// Set up the tables before the prober is used; it is safe to use from many
+// goroutines as long as the tables are not changed.
+type ScriptedProber struct {
+	hosts    map[string][]net.IP
+	local    []net.IP
+	handlers map[string]func(conn net.Conn)
+}
+
+// Create a ScriptedProber that knows no hosts and has every port closed
+func NewScriptedProber() *ScriptedProber {
+	return &ScriptedProber{
+		hosts:    make(map[string][]net.IP),
+		handlers: make(map[string]func(conn net.Conn)),
+	}
+}
+
+// Add the IP addresses of a host
+func (prober *ScriptedProber) AddHost(host string, ips ...string) {
+	for _, ip := range ips {
+		prober.hosts[host] = append(prober.hosts[host], net.ParseIP(ip))
+	}
+}
+
+// Add IP addresses that belong to this machine
+func (prober *ScriptedProber) AddLocal(ips ...string) {
+	for _, ip := range ips {
+		prober.local = append(prober.local, net.ParseIP(ip))
+	}
+}
+
+// Open a port, eg: Open("tcp", "10.0.0.1:80"). Connections are accepted,
+// and anything sent is read and ignored.
+func (prober *ScriptedProber) Open(network, address string) {
+	prober.Handle(network, address, func(conn net.Conn) {
+		io.Copy(ioutil.Discard, conn)
+		conn.Close()
+	})
+}
+
+// Open a port, and answer each connection to it with handler, which is given
+// the server side of the connection
+func (prober *ScriptedProber) Handle(network, address string, handler func(conn net.Conn)) {
+	prober.handlers[network+"/"+address] = handler
+}
+
+// Filter a port, so that connections to it time out
+func (prober *ScriptedProber) Filter(network, address string) {
+	prober.handlers[network+"/"+address] = nil
+}
+
+func (prober *ScriptedProber) LookupIP(host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	ips, ok := prober.hosts[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host}
+	}
+	return ips, nil
+}
+
+func (prober *ScriptedProber) LocalIPs() ([]net.IP, error) {
+	return prober.local, nil
+}
+
+func (prober *ScriptedProber) Dial(network, address string, timeout time.Duration) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := prober.LookupIP(host)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+	for _, ip := range ips {
+		handler, ok := prober.handlers[network+"/"+net.JoinHostPort(ip.String(), port)]
+		if !ok {
+			continue
+		}
+		if handler == nil {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: scriptedTimeout{}}
+		}
+		client, server := net.Pipe()
+		go handler(server)
+		return client, nil
+	}
+	return nil, &net.OpError{Op: "dial", Net: network, Err: errors.New("connection refused")}
+}
+
+// The error returned by ScriptedProber when a connection times out
+type scriptedTimeout struct{}
+
+func (err scriptedTimeout) Error() string   { return "i/o timeout" }
+func (err scriptedTimeout) Timeout() bool   { return true }
+func (err scriptedTimeout) Temporary() bool { return true }
@@ -0,0 +1,219 @@
+package flower
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strconv"
+	"sync"
+)
+
+// Constructor builds a command from the arguments captured by the named
+// subexpressions of its pattern, or returns nil if they are invalid
+type Constructor func(params map[string]string) Command
+
+// Registry holds the directives that can be parsed, each with a name, a
+// pattern and a constructor. Patterns are tried in the order they were
+// registered. A Registry is safe to use from many goroutines
+type Registry struct {
+	lock     sync.RWMutex
+	commands []registration
+
+	// The services that may be named without a port
+	services *Catalogue
+}
+
+type registration struct {
+	name        string
+	pattern     *regexp.Regexp
+	constructor Constructor
+
+	// The words that appear literally in the pattern, such as its verb
+	words map[string]bool
+}
+
+// Create a Registry with no commands, that knows the well known services
+func NewRegistry() *Registry {
+	return &Registry{services: NewDefaultCatalogue()}
+}
+
+// Return the services that may be named without a port
+func (registry *Registry) Catalogue() *Catalogue {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	return registry.services
+}
+
+// Replace the services that may be named without a port
+func (registry *Registry) SetCatalogue(catalogue *Catalogue) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	registry.services = catalogue
+}
+
+// Create a Registry with the built in commands
+func NewDefaultRegistry() *Registry {
+	registry := NewRegistry()
+	registerBuiltins(registry)
+	return registry
+}
+
+// Add a command. Before the constructor is called the arguments named port,
+// service, status, timeout, retries and certoptions are checked in the same
+// way as for the built in commands, and when there is a service but no port
+// the port and protocol arguments are filled in from the catalogue. Returns
+// an error if the name is taken
+func (registry *Registry) Register(name string, pattern *regexp.Regexp, constructor Constructor) error {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	for _, command := range registry.commands {
+		if command.name == name {
+			return fmt.Errorf("command %s is already registered", name)
+		}
+	}
+	words := make(map[string]bool)
+	if parsed, err := syntax.Parse(pattern.String(), syntax.Perl); err == nil {
+		literals(parsed, words)
+	}
+	registry.commands = append(registry.commands, registration{name, pattern, constructor, words})
+	return nil
+}
+
+// Add the literal strings in a parsed regular expression to words
+func literals(re *syntax.Regexp, words map[string]bool) {
+	if re.Op == syntax.OpLiteral {
+		words[string(re.Rune)] = true
+	}
+	for _, sub := range re.Sub {
+		literals(sub, words)
+	}
+}
+
+// Like Register, but panics if the name is taken
+func (registry *Registry) MustRegister(name string, pattern *regexp.Regexp, constructor Constructor) {
+	if err := registry.Register(name, pattern, constructor); err != nil {
+		panic(err)
+	}
+}
+
+// Remove a command, returning true if it was registered
+func (registry *Registry) Unregister(name string) bool {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	for i, command := range registry.commands {
+		if command.name == name {
+			registry.commands = append(registry.commands[:i:i], registry.commands[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Return the names of the commands, in the order they are tried
+func (registry *Registry) Names() []string {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	names := make([]string, 0, len(registry.commands))
+	for _, command := range registry.commands {
+		names = append(names, command.name)
+	}
+	return names
+}
+
+// Parse a string, find a matching command. If the line starts with flower:
+// but is not a valid directive return nil and a diagnostic saying why. The
+// diagnostic's line number is left for the caller to fill in
+func (registry *Registry) Parse(line string) (Command, *Diagnostic) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	for _, command := range registry.commands {
+		match := command.pattern.FindStringSubmatchIndex(line)
+		if match == nil {
+			continue
+		}
+		// Turn parenthesized sub expressions into a map[string]string, and
+		// note the column each starts at
+		params := make(map[string]string)
+		columns := make(map[string]int)
+		for i, key := range command.pattern.SubexpNames() {
+			if key == "" || match[2*i] < 0 {
+				continue
+			}
+			params[key] = line[match[2*i]:match[2*i+1]]
+			columns[key] = match[2*i] + 1
+		}
+		if reason, key := checkParams(params, registry.services); reason != "" {
+			return nil, &Diagnostic{Column: columns[key], Directive: line, Reason: reason}
+		}
+		registry.fillService(params)
+		if cmd := command.constructor(params); cmd != nil {
+			return cmd, nil
+		}
+		return nil, &Diagnostic{Column: 1, Directive: line, Reason: "invalid " + command.name}
+	}
+	if !IsDirective(line) {
+		return nil, nil
+	}
+	reason, column := unmatchedReason(line, registry.knownVerb)
+	return nil, &Diagnostic{Column: column, Directive: line, Reason: reason}
+}
+
+// Fill in the port and protocol of a service named without them from the
+// catalogue
+func (registry *Registry) fillService(params map[string]string) {
+	if service, ok := params["service"]; ok {
+		if params["port"] == "" {
+			port, _ := registry.services.Port(service)
+			params["port"] = strconv.Itoa(port)
+		}
+		if params["protocol"] == "" {
+			params["protocol"] = registry.services.Protocol(service)
+		}
+	}
+}
+
+// Build the command with the given name from its arguments, as Parse would
+// had they been captured from a directive. Returns nil if there is no such
+// command or the arguments are invalid
+func (registry *Registry) build(name string, params map[string]string) Command {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	for _, command := range registry.commands {
+		if command.name != name {
+			continue
+		}
+		if reason, _ := checkParams(params, registry.services); reason != "" {
+			return nil
+		}
+		// leave the caller's map as it was
+		filled := make(map[string]string, len(params)+2)
+		for key, value := range params {
+			filled[key] = value
+		}
+		registry.fillService(filled)
+		return command.constructor(filled)
+	}
+	return nil
+}
+
+// Return the pattern of each command, by name
+func (registry *Registry) patterns() map[string]*regexp.Regexp {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	patterns := make(map[string]*regexp.Regexp, len(registry.commands))
+	for _, command := range registry.commands {
+		patterns[command.name] = command.pattern
+	}
+	return patterns
+}
+
+// Return true if a word is used as a verb by one of the commands, that is it
+// appears literally in a pattern
+func (registry *Registry) knownVerb(verb string) bool {
+	for _, command := range registry.commands {
+		if command.words[verb] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package flower
+
+import (
+	"regexp"
+	"sync"
+	"testing"
+)
+
+var replicatesTo = regexp.MustCompile(`^\s*flower:\s*(?P<primary>\S+)\s+replicates-to\s+(?P<replica>[^\s:]+)(:(?P<port>\d+))?\s*$`)
+
+func buildReplicatesTo(params map[string]string) Command {
+	port := 5432
+	if params["port"] != "" {
+		port = servicePort(params)
+	}
+	return NewServiceCommand(params["replica"], "postgres", port, params["primary"])
+}
+
+func Test_Registry_Builtins(t *testing.T) {
+	expected := []string{"host_alias", "set_variable", "local_service", "http_check", "tls_check", "remote_service"}
+	names := NewDefaultRegistry().Names()
+	if len(names) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, names)
+		}
+	}
+	if len(NewRegistry().Names()) != 0 {
+		t.Errorf("Expected an empty registry")
+	}
+}
+
+func Test_Registry_Register(t *testing.T) {
+	registry := NewDefaultRegistry()
+	if err := registry.Register("replicates_to", replicatesTo, buildReplicatesTo); err != nil {
+		t.Fatalf("Register returned error: %v", err)
+	}
+	if err := registry.Register("replicates_to", replicatesTo, buildReplicatesTo); err == nil {
+		t.Errorf("Expected an error registering a name twice")
+	}
+
+	input := "flower: db1 replicates-to db2"
+	cmd, diagnostic := registry.Parse(input)
+	if diagnostic != nil {
+		t.Errorf("Input %s, unexpected diagnostic %s", input, diagnostic)
+	}
+	if actual, ok := cmd.(*ServiceCommand); !ok || actual.host != "db2" || actual.caller != "db1" {
+		t.Errorf("Input %s, unexpected command %v", input, cmd)
+	}
+
+	// the arguments are checked as for the built in commands
+	_, diagnostic = registry.Parse("flower: db1 replicates-to db2:99999")
+	if diagnostic == nil || diagnostic.Reason != "port out of range" || diagnostic.Column != 31 {
+		t.Errorf("Expected port out of range, got %v", diagnostic)
+	}
+	_, diagnostic = registry.Parse("flower: db1 replicates-to")
+	if diagnostic == nil || diagnostic.Reason != "malformed replicates-to directive" {
+		t.Errorf("Expected a malformed directive, got %v", diagnostic)
+	}
+
+	if !registry.Unregister("replicates_to") || registry.Unregister("replicates_to") {
+		t.Errorf("Expected to unregister once")
+	}
+	_, diagnostic = registry.Parse(input)
+	if diagnostic == nil || diagnostic.Reason != `unknown verb "replicates-to"` {
+		t.Errorf("Expected an unknown verb, got %v", diagnostic)
+	}
+}
+
+func Test_Registry_PerInterpreter(t *testing.T) {
+	custom := NewInterpreter()
+	custom.Registry().MustRegister("replicates_to", replicatesTo, buildReplicatesTo)
+	custom.Registry().Unregister("http_check")
+	standard := NewInterpreter()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			custom.Registry().Parse("flower: db1 replicates-to db2")
+		}()
+		go func() {
+			defer wg.Done()
+			standard.Registry().Parse("flower: db1 replicates-to db2")
+		}()
+	}
+	wg.Wait()
+
+	if _, ok := custom.EvaluateCode("flower: db1 replicates-to db2").(*ServiceCommand); !ok {
+		t.Errorf("Expected the custom interpreter to know replicates-to")
+	}
+	if _, ok := standard.EvaluateCode("flower: db1 replicates-to db2").(*SyntaxErrorCommand); !ok {
+		t.Errorf("Expected the standard interpreter not to know replicates-to")
+	}
+	if _, ok := custom.EvaluateCode("flower: web offers http path / expect 200").(*SyntaxErrorCommand); !ok {
+		t.Errorf("Expected the custom interpreter not to know http checks")
+	}
+	if _, ok := standard.EvaluateCode("flower: web offers http path / expect 200").(*HttpCommand); !ok {
+		t.Errorf("Expected the standard interpreter to know http checks")
+	}
+}
@@ -0,0 +1,155 @@
+package flower
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+)
+
+// The form of a Result in a JSON report
+type jsonResult struct {
+	Directive string  `json:"directive"`
+	Host      string  `json:"host"`
+	Service   string  `json:"service"`
+	Port      int     `json:"port"`
+	Caller    string  `json:"caller"`
+	Match     string  `json:"match"`
+	Result    string  `json:"result"`
+	Error     string  `json:"error,omitempty"`
+	Reason    string  `json:"reason,omitempty"`
+	Duration  float64 `json:"duration"`
+}
+
+// Return the form of a result in a JSON report
+func newJSONResult(result Result) jsonResult {
+	object := jsonResult{
+		Directive: result.Directive,
+		Host:      result.Host,
+		Service:   result.Service,
+		Port:      result.Port,
+		Caller:    result.Caller,
+		Match:     MatchName(result.Match),
+		Result:    ResultName(result.Result),
+		Reason:    result.Reason,
+		Duration:  result.Duration.Seconds(),
+	}
+	if result.Err != nil {
+		object.Error = result.Err.Error()
+	}
+	return object
+}
+
+// Return the result a JSON object describes
+func (object jsonResult) result() Result {
+	result := Result{
+		Directive: object.Directive,
+		Host:      object.Host,
+		Service:   object.Service,
+		Port:      object.Port,
+		Caller:    object.Caller,
+		Match:     matchByName(object.Match),
+		Result:    resultByName(object.Result),
+		Reason:    object.Reason,
+		Duration:  time.Duration(object.Duration * float64(time.Second)),
+	}
+	if object.Error != "" {
+		result.Err = errors.New(object.Error)
+	}
+	return result
+}
+
+// Write results as a JSON array, with one object per command. Match and
+// result are given by name, and duration is in seconds
+func WriteJSON(w io.Writer, results []Result) error {
+	objects := make([]jsonResult, 0, len(results))
+	for _, result := range results {
+		objects = append(objects, newJSONResult(result))
+	}
+
+	data, err := json.MarshalIndent(objects, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	_, err = w.Write(data)
+	return err
+}
+
+// The form of results in a JUnit XML report
+type junitTestSuite struct {
+	XMLName  xml.Name        `xml:"testsuite"`
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+}
+
+// Write results as a JUnit XML test suite, with one test case per directive.
+// FAIL and TIMEOUT are failures, ERROR is an error, and commands that do not
+// apply to this host or were not run are skipped
+func WriteJUnit(w io.Writer, results []Result) error {
+	suite := junitTestSuite{Name: "flower"}
+	var total float64
+	for _, result := range results {
+		testCase := junitTestCase{
+			Name:      result.Directive,
+			Classname: result.Host,
+			Time:      strconv.FormatFloat(result.Duration.Seconds(), 'f', 3, 64),
+		}
+		message := ResultName(result.Result)
+		if result.Err != nil {
+			message += ": " + result.Err.Error()
+		} else if result.Reason != "" {
+			message += ": " + result.Reason
+		}
+
+		switch {
+		case result.Result == ERROR || result.Err != nil:
+			testCase.Error = &junitMessage{message}
+			suite.Errors++
+		case result.Result == FAIL || result.Result == TIMEOUT:
+			testCase.Failure = &junitMessage{message}
+			suite.Failures++
+		case result.Result == NO_ANSWER:
+			testCase.Skipped = &junitMessage{MatchName(result.Match)}
+			suite.Skipped++
+		case result.Result == NOT_RUN:
+			testCase.Skipped = &junitMessage{message}
+			suite.Skipped++
+		}
+		suite.Cases = append(suite.Cases, testCase)
+		total += result.Duration.Seconds()
+	}
+	suite.Tests = len(suite.Cases)
+	suite.Time = strconv.FormatFloat(total, 'f', 3, 64)
+
+	data, err := xml.MarshalIndent(suite, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,99 @@
+package flower
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+// Return an interpreter that has run one command of each result
+func reportInterpreter() *StandardInterpreter {
+	prober := testProber()
+	prober.Open("tcp", "10.0.0.2:80")
+	prober.Filter("tcp", "10.0.0.2:8080")
+
+	interpreter := NewInterpreter()
+	interpreter.SetProber(prober)
+	interpreter.EvaluateCode("flower: here offers http")
+	interpreter.EvaluateCode("flower: here offers http:81")
+	interpreter.EvaluateCode("flower: here offers http:8080")
+	interpreter.EvaluateCode("flower: www.gogle.com offers http")
+	interpreter.EvaluateCode("flower: nowhere offers http")
+	interpreter.Run()
+	return interpreter
+}
+
+func Test_Results(t *testing.T) {
+	results := reportInterpreter().Results()
+	expected := []int{OK, FAIL, TIMEOUT, NO_ANSWER, ERROR}
+	if len(results) != len(expected) {
+		t.Fatalf("Expected %d results, got %d", len(expected), len(results))
+	}
+	for i, result := range results {
+		if result.Result != expected[i] {
+			t.Errorf("Expected %s, got %s for %s", ResultName(expected[i]), ResultName(result.Result), result.Directive)
+		}
+	}
+	if results[1].Directive != "flower: here offers http:81" || results[1].Port != 81 || results[1].Caller != "here" {
+		t.Errorf("Unexpected result %+v", results[1])
+	}
+}
+
+func Test_WriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, reportInterpreter().Results()); err != nil {
+		t.Fatalf("WriteJSON returned error: %v", err)
+	}
+
+	var objects []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &objects); err != nil {
+		t.Fatalf("WriteJSON wrote invalid JSON: %v\n%s", err, buf.String())
+	}
+	if len(objects) != 5 {
+		t.Fatalf("Expected 5 objects, got %d", len(objects))
+	}
+	first := objects[0]
+	if first["host"] != "here" || first["service"] != "http" || first["port"] != 80.0 ||
+		first["match"] != "MATCH_HOST" || first["result"] != "OK" || first["error"] != nil {
+		t.Errorf("Unexpected object %v", first)
+	}
+	if _, ok := first["duration"].(float64); !ok {
+		t.Errorf("Expected a duration, got %v", first)
+	}
+	if objects[4]["result"] != "ERROR" || objects[4]["error"] == nil {
+		t.Errorf("Expected an error, got %v", objects[4])
+	}
+}
+
+func Test_WriteJUnit(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteJUnit(&buf, reportInterpreter().Results()); err != nil {
+		t.Fatalf("WriteJUnit returned error: %v", err)
+	}
+
+	var suite junitTestSuite
+	if err := xml.Unmarshal(buf.Bytes(), &suite); err != nil {
+		t.Fatalf("WriteJUnit wrote invalid XML: %v\n%s", err, buf.String())
+	}
+	if suite.Tests != 5 || suite.Failures != 2 || suite.Errors != 1 || suite.Skipped != 1 {
+		t.Errorf("Unexpected counts in %s", buf.String())
+	}
+	if suite.Cases[0].Name != "flower: here offers http" || suite.Cases[0].Failure != nil {
+		t.Errorf("Unexpected test case %+v", suite.Cases[0])
+	}
+	if suite.Cases[2].Failure == nil || !strings.HasPrefix(suite.Cases[2].Failure.Message, "TIMEOUT") {
+		t.Errorf("Expected a timeout failure, got %+v", suite.Cases[2])
+	}
+}
+
+func Test_SummaryReport(t *testing.T) {
+	summary := string(reportInterpreter().SummaryReport())
+	if strings.Contains(summary, "</tr></td>") {
+		t.Errorf("Mis-nested tags in %s", summary)
+	}
+	if strings.Count(summary, "<tr><td") != 5 {
+		t.Errorf("Expected a row for each command in %s", summary)
+	}
+}
@@ -0,0 +1,86 @@
+package flower
+
+import (
+	"time"
+)
+
+// Result describes a command once it has been executed, for use in reports
+type Result struct {
+	// The directive the command was parsed from
+	Directive string
+
+	// The service's host, service name and port, and the host that calls it.
+	// For an alias, Host is the alias and Caller is empty
+	Host    string
+	Service string
+	Port    int
+	Caller  string
+
+	// Whether the command applies to this host, see the MATCH_ constants
+	Match int
+
+	// The outcome of the command, see the result constants. A command that
+	// could not be checked because of an error is always ERROR
+	Result int
+
+	// The error that stopped the command being checked, if any
+	Err error
+
+	// Why the check failed, if it was able to say more than its result
+	Reason string
+
+	// How long the command took to execute
+	Duration time.Duration
+}
+
+// Return the name of a MATCH_ constant
+func MatchName(match int) string {
+	switch match {
+	case MATCH_HOST:
+		return "MATCH_HOST"
+	case MATCH_CALLER:
+		return "MATCH_CALLER"
+	case NO_MATCH:
+		return "NO_MATCH"
+	}
+	return "UNKNOWN"
+}
+
+// Return the name of a result constant
+func ResultName(result int) string {
+	switch result {
+	case NO_ANSWER:
+		return "NO_ANSWER"
+	case ERROR:
+		return "ERROR"
+	case OK:
+		return "OK"
+	case FAIL:
+		return "FAIL"
+	case TIMEOUT:
+		return "TIMEOUT"
+	case NOT_RUN:
+		return "NOT_RUN"
+	}
+	return "UNKNOWN"
+}
+
+// Return the MATCH_ constant with a name, or NO_MATCH if there is none
+func matchByName(name string) int {
+	for _, match := range []int{MATCH_HOST, MATCH_CALLER} {
+		if MatchName(match) == name {
+			return match
+		}
+	}
+	return NO_MATCH
+}
+
+// Return the result constant with a name, or ERROR if there is none
+func resultByName(name string) int {
+	for _, result := range []int{NO_ANSWER, OK, FAIL, TIMEOUT, NOT_RUN} {
+		if ResultName(result) == name {
+			return result
+		}
+	}
+	return ERROR
+}
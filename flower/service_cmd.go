@@ -1,11 +1,11 @@
 package flower
 
 import (
-	//	"fmt"
-	"github.com/anvie/port-scanner"
+	"fmt"
 	"net"
 	//	"strings"
 	"strconv"
+	"time"
 )
 
 // Constants used to indicate if a command applies to this host
@@ -21,6 +21,8 @@ const (
 	ERROR     = 1
 	OK        = 2
 	FAIL      = 3
+	TIMEOUT   = 4
+	NOT_RUN   = 5
 )
 
 
@@ -37,21 +39,42 @@ type ServiceCommand struct {
 	service string
 	port    int
 
+	// The protocol used to check the service, see protocolProbes
+	protocol string
+
 	// The host that calls the service
 	caller string
 
+	// Set for `uses` directives, where caller is another host, rather than
+	// `offers` directives
+	uses bool
+
+	// The addresses that host and caller are aliases for, if any
+	hostAddress   string
+	callerAddress string
+
+	// How long to wait to connect, and how many times to retry. Zero timeout
+	// and negative retries mean use the interpreter's settings
+	timeout time.Duration
+	retries int
+
 	// If an error occurs store it here
 	err error
+
+	// The caller whose executor ran the check, when it was not run here
+	via string
 }
 
 func NewServiceCommand(host string, service string, port int, caller string) *ServiceCommand {
 	cmd := new(ServiceCommand)
 	cmd.match = NO_MATCH
-	cmd.result = NO_ANSWER
+	cmd.result = NOT_RUN
 	cmd.host = host
 	cmd.service = service
 	cmd.port = port
 	cmd.caller = caller
+	cmd.protocol = "tcp"
+	cmd.retries = -1
 	return cmd
 }
 
@@ -75,78 +98,148 @@ func (cmd *ServiceCommand) HtmlClass() string {
 		class += "FLOWER-OK"
 	case FAIL:
 		class += "FLOWER-FAIL"
+	case TIMEOUT:
+		class += "FLOWER-TIMEOUT"
+	case NOT_RUN:
+		class += "FLOWER-NOT-RUN"
 	}
-	
+
 	if cmd.err != nil {
 		class += " FLOWER-ERROR"
 	}
 	return class
 }
 
-func (cmd *ServiceCommand) Execute() {
+func (cmd *ServiceCommand) Execute(settings *Settings) {
+	prober := settings.prober()
+	target := cmd.locate(prober)
+	if target == "" {
+		return
+	}
+	timeout, retries := cmd.checkSettings(settings)
 
-	var matchHost bool
-	matchHost, cmd.err = HostIsLocalhost(cmd.host)
-	if cmd.err != nil {
+	probe, ok := protocolProbes[cmd.protocol]
+	if !ok {
+		cmd.err = fmt.Errorf("no way to check protocol %s", cmd.protocol)
 		return
 	}
 
-	var matchCaller bool
-	matchCaller, cmd.err = HostIsLocalhost(cmd.caller)
+	// Scanning
+	address := net.JoinHostPort(target, strconv.Itoa(cmd.port))
+	for attempt := 0; attempt <= retries; attempt++ {
+		cmd.result = probe(prober, address, timeout)
+		if cmd.result == OK {
+			return
+		}
+	}
+}
+
+// Work out whether the command applies to this machine, as the host or the
+// caller, and set match. Returns the host to check, or "" if there is nothing
+// to check
+func (cmd *ServiceCommand) locate(prober Prober) string {
+	cmd.result = NO_ANSWER
+	var hostIP net.IP
+	hostIP, cmd.err = LocalAddress(prober, cmd.hostAddr())
 	if cmd.err != nil {
-		return
+		return ""
 	}
 
-	var ps *portscanner.PortScanner
+	var callerIP net.IP
+	callerIP, cmd.err = LocalAddress(prober, cmd.callerAddr())
+	if cmd.err != nil {
+		return ""
+	}
 
-	if matchHost {
+	// A range of addresses can be checked only when this machine is in the range
+	target := cmd.hostAddr()
+	if hostIP != nil {
 		cmd.match = MATCH_HOST
-		ps = portscanner.NewPortScanner(cmd.host)
-	} else if matchCaller {
+		if IsAddressPattern(target) {
+			target = hostIP.String()
+		}
+	} else if callerIP != nil {
 		cmd.match = MATCH_CALLER
-		ps = portscanner.NewPortScanner(cmd.host)
+		if IsAddressPattern(target) {
+			cmd.err = fmt.Errorf("cannot check %s, it is a range of addresses", target)
+			return ""
+		}
 	} else {
 		cmd.match = NO_MATCH
-		return
+		return ""
 	}
+	return target
+}
 
-	// Scanning
-	openedPorts := ps.GetOpenedPort(cmd.port, cmd.port)
-	if len(openedPorts) == 1 {
-		cmd.result = OK
-	} else {
-		cmd.result = FAIL
+// Return the timeout and number of retries for the command, taken from the
+// directive or else from the interpreter's settings
+func (cmd *ServiceCommand) checkSettings(settings *Settings) (time.Duration, int) {
+	timeout := cmd.timeout
+	if timeout <= 0 {
+		timeout = settings.Timeout
+	}
+	retries := cmd.retries
+	if retries < 0 {
+		retries = settings.Retries
+	}
+	// settings may come from an agent request, so are not to be trusted
+	if retries > MAX_RETRIES {
+		retries = MAX_RETRIES
+	}
+	return timeout, retries
+}
+
+// Look up the host and caller in a table of aliases, so that they are checked
+// using the addresses the aliases stand for
+func (cmd *ServiceCommand) resolveAliases(aliases map[string]string) {
+	cmd.hostAddress = aliases[cmd.host]
+	cmd.callerAddress = aliases[cmd.caller]
+}
+
+// Return the address of the host, or its name if it is not an alias
+func (cmd *ServiceCommand) hostAddr() string {
+	if cmd.hostAddress != "" {
+		return cmd.hostAddress
 	}
+	return cmd.host
+}
+
+// Return the address of the caller, or its name if it is not an alias
+func (cmd *ServiceCommand) callerAddr() string {
+	if cmd.callerAddress != "" {
+		return cmd.callerAddress
+	}
+	return cmd.caller
 }
 
 func (cmd *ServiceCommand) String() string {
 	str := "host:" + cmd.host
+	if cmd.hostAddress != "" {
+		str += ", host_ip:" + cmd.hostAddress
+	}
 	if cmd.service == "" {
 		str += ", service:" + cmd.service
 	}
 	str += ", port:" + strconv.Itoa(cmd.port)
+	if cmd.protocol != "tcp" {
+		str += ", protocol:" + cmd.protocol
+	}
 	if cmd.caller != "" {
 		str += ", caller:" + cmd.caller
 	}
-	str += ", match:"
-	switch cmd.match {
-	case MATCH_HOST:
-		str += "MATCH_HOST"
-	case MATCH_CALLER:
-		str += "MATCH_CALLER"
-	case NO_MATCH:
-		str += "NO_MATCH"
+	if cmd.callerAddress != "" {
+		str += ", caller_ip:" + cmd.callerAddress
 	}
-	str += ", result:"
-	switch cmd.result {
-	case NO_ANSWER:
-		str += "NO_ANSWER"
-	case ERROR:
-		str += "ERROR"
-	case OK:
-		str += "OK"
-	case FAIL:
-		str += "FAIL"
+	if cmd.timeout > 0 {
+		str += ", timeout:" + cmd.timeout.String()
+	}
+	if cmd.retries >= 0 {
+		str += ", retries:" + strconv.Itoa(cmd.retries)
+	}
+	str += ", match:" + MatchName(cmd.match)
+	str += ", result:" + ResultName(cmd.result)
+	if cmd.via != "" {
+		str += ", via:" + cmd.via
 	}
 	if cmd.err != nil {
 		str += ", error:"
@@ -156,27 +249,70 @@ func (cmd *ServiceCommand) String() string {
 	return str
 }
 
+// Take the result of a check run by the executor for a caller
+func (cmd *ServiceCommand) applyResult(result Result, via string) {
+	cmd.match = result.Match
+	cmd.result = result.Result
+	cmd.err = result.Err
+	cmd.via = via
+}
+
+func (cmd *ServiceCommand) Report() Result {
+	result := Result{
+		Host:    cmd.host,
+		Service: cmd.service,
+		Port:    cmd.port,
+		Caller:  cmd.caller,
+		Match:   cmd.match,
+		Result:  cmd.result,
+		Err:     cmd.err,
+	}
+	if cmd.err != nil {
+		result.Result = ERROR
+	}
+	return result
+}
+
 // Return true if host name passed in, is the local host machine, otherwise returns false
 func HostIsLocalhost(host string) (bool, error) {
-	addr, err := net.InterfaceAddrs()
+	return HostIsLocal(NetProber{}, host)
+}
+
+// Return true if the host name passed in is the machine that resolver runs on,
+// otherwise returns false
+func HostIsLocal(resolver Resolver, host string) (bool, error) {
+	ip, err := LocalAddress(resolver, host)
+	return ip != nil, err
+}
+
+// Return the IP address of this machine that host refers to, or nil if host is
+// another machine. host may be a name, an IP address, or an address pattern
+// such as 10.0.*
+func LocalAddress(resolver Resolver, host string) (net.IP, error) {
+	localhost_ips, err := resolver.LocalIPs()
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+
+	if IsAddressPattern(host) {
+		for _, localhost_ip := range localhost_ips {
+			if MatchAddressPattern(host, localhost_ip) {
+				return localhost_ip, nil
+			}
+		}
+		return nil, nil
 	}
 
-	host_ip, err := net.LookupIP(host)
+	host_ip, err := resolver.LookupIP(host)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	for _, address := range addr {
-		localhost_ip, _, err := net.ParseCIDR(address.String())
-		if err != nil {
-			return false, err
-		}
+	for _, localhost_ip := range localhost_ips {
 		for _, ip := range host_ip {
 			if ip.Equal(localhost_ip) {
-				return true, nil
+				return localhost_ip, nil
 			}
 		}
 	}
-	return false, nil
+	return nil, nil
 }
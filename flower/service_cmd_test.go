@@ -1,7 +1,9 @@
 package flower
 
 import (
+	"net"
 	"testing"
+	"time"
 )
 
 func Test_HostIsLocalhost_True(t *testing.T) {
@@ -15,9 +17,19 @@ func Test_HostIsLocalhost_True(t *testing.T) {
 	}
 }
 
+// A prober for a machine at 10.0.0.2 that knows about one other host
+func testProber() *ScriptedProber {
+	prober := NewScriptedProber()
+	prober.AddLocal("127.0.0.1", "10.0.0.2")
+	prober.AddHost("localhost", "127.0.0.1")
+	prober.AddHost("here", "10.0.0.2")
+	prober.AddHost("www.gogle.com", "142.250.0.1")
+	return prober
+}
+
 func Test_HostIsLocalhost_False(t *testing.T) {
 	hostname := "www.gogle.com"
-	match, err := HostIsLocalhost(hostname)
+	match, err := HostIsLocal(testProber(), hostname)
 	if err != nil {
 		t.Errorf("HostIsLocalhost('%s') returned error: %v", hostname, err)
 	}
@@ -28,8 +40,68 @@ func Test_HostIsLocalhost_False(t *testing.T) {
 
 func Test_HostIsLocalhost_Error(t *testing.T) {
 	hostname := "there_is_no_such_hast_as_this"
-	_, err := HostIsLocalhost(hostname)
+	_, err := HostIsLocal(testProber(), hostname)
 	if err == nil {
 		t.Errorf("HostIsLocalhost('%s') should returned an error", hostname)
 	}
 }
+
+func Test_ScanPort_Open(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen returned error: %v", err)
+	}
+	defer listener.Close()
+
+	result := ScanPort(NetProber{}, listener.Addr().String(), time.Second)
+	if result != OK {
+		t.Errorf("ScanPort('%s') should return OK, got %d", listener.Addr(), result)
+	}
+}
+
+func Test_ScanPort_Refused(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen returned error: %v", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	result := ScanPort(NetProber{}, address, time.Second)
+	if result != FAIL {
+		t.Errorf("ScanPort('%s') should return FAIL, got %d", address, result)
+	}
+}
+
+func Test_Execute_Scripted(t *testing.T) {
+	prober := testProber()
+	prober.Open("tcp", "10.0.0.2:80")
+	prober.Filter("tcp", "10.0.0.2:8080")
+	prober.Open("tcp", "142.250.0.1:25")
+	settings := &Settings{Timeout: time.Second, Prober: prober}
+
+	tests := []struct {
+		cmd    *ServiceCommand
+		match  int
+		result int
+	}{
+		{NewServiceCommand("here", "http", 80, "here"), MATCH_HOST, OK},
+		{NewServiceCommand("here", "http", 81, "here"), MATCH_HOST, FAIL},
+		{NewServiceCommand("here", "http", 8080, "here"), MATCH_HOST, TIMEOUT},
+		{NewServiceCommand("www.gogle.com", "smtp", 25, "here"), MATCH_CALLER, OK},
+		{NewServiceCommand("www.gogle.com", "http", 80, "here"), MATCH_CALLER, FAIL},
+		{NewServiceCommand("www.gogle.com", "smtp", 25, "www.gogle.com"), NO_MATCH, NO_ANSWER},
+	}
+	for _, test := range tests {
+		test.cmd.Execute(settings)
+		if test.cmd.match != test.match || test.cmd.result != test.result {
+			t.Errorf("Expected match %d result %d, got %s", test.match, test.result, test.cmd)
+		}
+	}
+
+	cmd := NewServiceCommand("nowhere", "http", 80, "here")
+	cmd.Execute(settings)
+	if cmd.err == nil {
+		t.Errorf("Expected an error for an unknown host, got %s", cmd)
+	}
+}
@@ -0,0 +1,54 @@
+package flower
+
+import (
+	"errors"
+	"strings"
+)
+
+// The prefix that marks a line of code as a flower directive
+const DIRECTIVE_PREFIX = "flower:"
+
+// SyntaxErrorCommand stands for a line that looks like a flower directive,
+// but is not one, so that it is reported rather than silently ignored
+type SyntaxErrorCommand struct {
+	// Where the line is, and what is wrong with it
+	diagnostic Diagnostic
+}
+
+func NewSyntaxErrorCommand(diagnostic Diagnostic) *SyntaxErrorCommand {
+	cmd := new(SyntaxErrorCommand)
+	cmd.diagnostic = diagnostic
+	return cmd
+}
+
+// Return true if a line of code is meant to be a flower directive
+func IsDirective(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), DIRECTIVE_PREFIX)
+}
+
+// Return the diagnostic for the line
+func (cmd *SyntaxErrorCommand) Diagnostic() Diagnostic {
+	return cmd.diagnostic
+}
+
+// There is nothing to check, so there is nothing to do when executed
+func (cmd *SyntaxErrorCommand) Execute(settings *Settings) {
+}
+
+func (cmd *SyntaxErrorCommand) HtmlClass() string {
+	return "FLOWER-SYNTAX FLOWER-ERROR"
+}
+
+func (cmd *SyntaxErrorCommand) String() string {
+	return "syntax error: " + cmd.diagnostic.String()
+}
+
+// A syntax error is always an ERROR, whether or not the commands were run
+func (cmd *SyntaxErrorCommand) Report() Result {
+	return Result{
+		Match:  NO_MATCH,
+		Result: ERROR,
+		Err:    errors.New("syntax error: " + cmd.diagnostic.Reason),
+		Reason: cmd.diagnostic.String(),
+	}
+}
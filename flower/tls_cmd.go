@@ -0,0 +1,207 @@
+package flower
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TlsCommand connects to a service with TLS and checks its certificate: that
+// the chain verifies, that it is for the right name, and that it is not about
+// to expire
+type TlsCommand struct {
+	ServiceCommand
+
+	// How long the certificate must remain valid for, or 0 to only check that
+	// it has not expired
+	validFor time.Duration
+
+	// The name the certificate must be for. When empty the host is used
+	name string
+
+	// A file of PEM encoded certificates to verify the chain against, instead
+	// of the system's or the interpreter's
+	caFile string
+
+	// Why the check failed
+	reason string
+}
+
+func NewTlsCommand(host string, service string, port int, validFor time.Duration, name string, caFile string) *TlsCommand {
+	cmd := new(TlsCommand)
+	cmd.ServiceCommand = *NewServiceCommand(host, service, port, host)
+	cmd.validFor = validFor
+	cmd.name = name
+	cmd.caFile = caFile
+	return cmd
+}
+
+func (cmd *TlsCommand) Execute(settings *Settings) {
+	prober := settings.prober()
+	target := cmd.locate(prober)
+	if target == "" {
+		return
+	}
+	timeout, retries := cmd.checkSettings(settings)
+
+	roots := settings.RootCAs
+	if cmd.caFile != "" {
+		roots, cmd.err = LoadCertPool(cmd.caFile)
+		if cmd.err != nil {
+			return
+		}
+	}
+
+	for attempt := 0; attempt <= retries; attempt++ {
+		cmd.result, cmd.reason = cmd.handshake(prober, target, timeout, roots)
+		if cmd.result == OK {
+			return
+		}
+	}
+}
+
+// Connect and check the certificate, returning the result and, if it was not
+// OK, the reason why
+func (cmd *TlsCommand) handshake(prober Prober, target string, timeout time.Duration, roots *x509.CertPool) (int, string) {
+	conn, err := prober.Dial("tcp", net.JoinHostPort(target, strconv.Itoa(cmd.port)), timeout)
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return TIMEOUT, err.Error()
+		}
+		return FAIL, err.Error()
+	}
+	defer conn.Close()
+
+	// The certificate is verified below, so that each problem can be reported
+	client := tls.Client(conn, &tls.Config{
+		ServerName:         cmd.certName(),
+		InsecureSkipVerify: true,
+	})
+	client.SetDeadline(time.Now().Add(timeout))
+	if err := client.Handshake(); err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return TIMEOUT, err.Error()
+		}
+		return FAIL, err.Error()
+	}
+
+	certificates := client.ConnectionState().PeerCertificates
+	if len(certificates) == 0 {
+		return FAIL, "no certificate"
+	}
+	return CheckCertificate(certificates, cmd.certName(), cmd.validFor, roots, time.Now())
+}
+
+// Return the name the certificate must be for
+func (cmd *TlsCommand) certName() string {
+	if cmd.name != "" {
+		return cmd.name
+	}
+	return cmd.host
+}
+
+// Check a certificate chain, the server's certificate first, returning OK or
+// else FAIL and the reason why. The certificate must be for name, must verify
+// against roots (or the system roots when nil), and must remain valid for
+// validFor after now
+func CheckCertificate(certificates []*x509.Certificate, name string, validFor time.Duration, roots *x509.CertPool, now time.Time) (int, string) {
+	leaf := certificates[0]
+	if now.After(leaf.NotAfter) {
+		return FAIL, "expired " + leaf.NotAfter.Format(time.RFC3339)
+	}
+	if now.Before(leaf.NotBefore) {
+		return FAIL, "not valid until " + leaf.NotBefore.Format(time.RFC3339)
+	}
+	if now.Add(validFor).After(leaf.NotAfter) {
+		return FAIL, "expires " + leaf.NotAfter.Format(time.RFC3339)
+	}
+
+	if leaf.VerifyHostname(name) != nil && leaf.Subject.CommonName != name {
+		return FAIL, "certificate is not for " + name
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, certificate := range certificates[1:] {
+		intermediates.AddCert(certificate)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+	})
+	if err != nil {
+		return FAIL, err.Error()
+	}
+	return OK, ""
+}
+
+// Read a file of PEM encoded certificates
+func LoadCertPool(filename string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %s", filename)
+	}
+	return pool, nil
+}
+
+// Parse a period of time, either as a number of days such as 30d, or as a
+// Go duration such as 12h. Negative periods are an error
+func ParseValidity(period string) (time.Duration, error) {
+	if strings.HasSuffix(period, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(period, "d"))
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("invalid number of days %q", period)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	duration, err := time.ParseDuration(period)
+	if err != nil {
+		return 0, err
+	}
+	if duration < 0 {
+		return 0, fmt.Errorf("negative validity %q", period)
+	}
+	return duration, nil
+}
+
+// Take the result of a check run by the executor for a caller
+func (cmd *TlsCommand) applyResult(result Result, via string) {
+	cmd.ServiceCommand.applyResult(result, via)
+	cmd.reason = result.Reason
+}
+
+func (cmd *TlsCommand) Report() Result {
+	result := cmd.ServiceCommand.Report()
+	result.Reason = cmd.reason
+	return result
+}
+
+func (cmd *TlsCommand) String() string {
+	str := cmd.ServiceCommand.String()
+	if cmd.validFor > 0 {
+		if cmd.validFor%(24*time.Hour) == 0 {
+			str += ", cert-valid-for:" + strconv.Itoa(int(cmd.validFor/(24*time.Hour))) + "d"
+		} else {
+			str += ", cert-valid-for:" + cmd.validFor.String()
+		}
+	}
+	if cmd.name != "" {
+		str += ", cert-cn:" + cmd.name
+	}
+	if cmd.caFile != "" {
+		str += ", ca:" + cmd.caFile
+	}
+	if cmd.reason != "" {
+		str += ", reason:" + cmd.reason
+	}
+	return str
+}
@@ -0,0 +1,85 @@
+package flower
+
+import (
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func Test_TlsCheck_Parse(t *testing.T) {
+	cmd, ok := Parse("flower: api.internal offers https cert-valid-for 30d").(*TlsCommand)
+	if !ok {
+		t.Fatalf("Expected a TlsCommand")
+	}
+	if cmd.host != "api.internal" || cmd.port != 443 || cmd.validFor != 30*24*time.Hour || cmd.name != "" {
+		t.Errorf("Unexpected command %s", cmd)
+	}
+
+	cmd, ok = Parse("flower: api.internal offers https:8443 cert-cn api.internal ca /etc/ca.pem cert-valid-for 12h retries 2").(*TlsCommand)
+	if !ok {
+		t.Fatalf("Expected a TlsCommand")
+	}
+	if cmd.port != 8443 || cmd.validFor != 12*time.Hour || cmd.name != "api.internal" || cmd.caFile != "/etc/ca.pem" || cmd.retries != 2 {
+		t.Errorf("Unexpected command %s", cmd)
+	}
+
+	if _, ok := Parse("flower: api.internal offers https").(*TlsCommand); ok {
+		t.Errorf("Expected a plain port check without cert- options")
+	}
+	if Parse("flower: api.internal offers https cert-valid-for soon") != nil {
+		t.Errorf("Expected no command for an invalid period")
+	}
+}
+
+func Test_TlsCheck_Execute(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	address, _ := url.Parse(server.URL)
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+
+	tests := []struct {
+		options string
+		roots   *x509.CertPool
+		result  int
+	}{
+		{"cert-valid-for 30d", roots, OK},
+		{"cert-cn example.com", roots, OK},
+		{"cert-cn example.org", roots, FAIL},
+		{"cert-valid-for 100000d", roots, FAIL},
+		{"cert-valid-for 30d", nil, FAIL},
+	}
+	for _, test := range tests {
+		directive := "flower: " + address.Hostname() + " offers https:" + address.Port() + " " + test.options
+		cmd := Parse(directive)
+		cmd.Execute(&Settings{Timeout: DEFAULT_TIMEOUT, RootCAs: test.roots})
+		if cmd.(*TlsCommand).result != test.result {
+			t.Errorf("Expected result %d, got %s. Input %s", test.result, cmd, directive)
+		}
+	}
+}
+
+func Test_ParseValidity(t *testing.T) {
+	tests := map[string]time.Duration{
+		"30d": 30 * 24 * time.Hour,
+		"0d":  0,
+		"36h": 36 * time.Hour,
+	}
+	for period, expected := range tests {
+		actual, err := ParseValidity(period)
+		if err != nil || actual != expected {
+			t.Errorf("ParseValidity('%s') expected %v, got %v %v", period, expected, actual, err)
+		}
+	}
+	for _, period := range []string{"-1d", "-5h", "-1ns"} {
+		if _, err := ParseValidity(period); err == nil {
+			t.Errorf("ParseValidity('%s') should return an error", period)
+		}
+	}
+	if cmd := Parse("flower: HOST1 offers https cert-valid-for -5h"); cmd != nil {
+		t.Errorf("Expected no command for a negative validity, got %v", cmd)
+	}
+}
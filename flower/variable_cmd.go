@@ -0,0 +1,136 @@
+package flower
+
+import (
+	"os"
+	"regexp"
+)
+
+// A reference to a variable in a directive, eg: ${env}
+var variableReference = regexp.MustCompile(`\$\{([A-Za-z_][\w\.\-]*)\}`)
+
+// VariableCommand defines a variable that the directives after it may use,
+// eg: 'flower: set env = staging'
+type VariableCommand struct {
+	// The variable's name and value
+	name  string
+	value string
+}
+
+func NewVariableCommand(name string, value string) *VariableCommand {
+	cmd := new(VariableCommand)
+	cmd.name = name
+	cmd.value = value
+	return cmd
+}
+
+// Variables are recorded by the interpreter as they are seen, so there is
+// nothing to do when executed
+func (cmd *VariableCommand) Execute(settings *Settings) {
+}
+
+func (cmd *VariableCommand) HtmlClass() string {
+	return "FLOWER-VARIABLE"
+}
+
+// The value is left out, since it may be secret
+func (cmd *VariableCommand) String() string {
+	return "variable:" + cmd.name
+}
+
+// A variable has nothing to check, so its result is OK once it is defined.
+// The value is left out, since it may be secret. Interpreters leave
+// variables out of their Results
+func (cmd *VariableCommand) Report() Result {
+	return Result{
+		Host:    cmd.name,
+		Service: "variable",
+		Match:   NO_MATCH,
+		Result:  OK,
+	}
+}
+
+func buildVariable(params map[string]string) Command {
+	return NewVariableCommand(params["name"], params["value"])
+}
+
+// Return the value of a variable. Values passed to the interpreter come
+// first, then those defined in the document, then the environment
+func (interpreter *StandardInterpreter) lookupVariable(name string) (string, bool) {
+	if value, ok := interpreter.variables[name]; ok {
+		return value, true
+	}
+	if value, ok := interpreter.definitions[name]; ok {
+		return value, true
+	}
+	return os.LookupEnv(name)
+}
+
+// Where the variable references in a directive were replaced: for each, the
+// start and end of the reference as written, then of its value once it is
+// substituted
+type substitutions [][4]int
+
+// Return the column in the directive as written of a column in the directive
+// once its variables are substituted. A column within a value is that of its
+// reference
+func (subs substitutions) original(column int) int {
+	i, shift := column-1, 0
+	for _, sub := range subs {
+		if i < sub[2] {
+			break
+		}
+		if i < sub[3] {
+			return sub[0] + 1
+		}
+		shift = sub[1] - sub[3]
+	}
+	return i + shift + 1
+}
+
+// Replace each reference to a variable in a directive with its value. Lines
+// that are not directives are left alone. Returns where the references were,
+// and a diagnostic for the first variable that is not defined
+func (interpreter *StandardInterpreter) substitute(line string) (string, substitutions, *Diagnostic) {
+	return substituteVariables(line, interpreter.lookupVariable)
+}
+
+// Return the values of the variables a directive refers to, so an executor
+// can substitute them where the directive is run
+func (interpreter *StandardInterpreter) referencedVariables(line string) map[string]string {
+	var values map[string]string
+	for _, match := range variableReference.FindAllStringSubmatch(line, -1) {
+		if value, ok := interpreter.lookupVariable(match[1]); ok {
+			if values == nil {
+				values = make(map[string]string)
+			}
+			values[match[1]] = value
+		}
+	}
+	return values
+}
+
+// Replace each reference to a variable in a directive with the value lookup
+// gives it, as StandardInterpreter.substitute does
+func substituteVariables(line string, lookup func(name string) (string, bool)) (string, substitutions, *Diagnostic) {
+	if !IsDirective(line) {
+		return line, nil, nil
+	}
+	var substituted []byte
+	var subs substitutions
+	last := 0
+	for _, match := range variableReference.FindAllStringSubmatchIndex(line, -1) {
+		name := line[match[2]:match[3]]
+		value, ok := lookup(name)
+		if !ok {
+			return line, nil, &Diagnostic{Column: match[0] + 1, Directive: line, Reason: "undefined variable " + name}
+		}
+		substituted = append(substituted, line[last:match[0]]...)
+		subs = append(subs, [4]int{match[0], match[1], len(substituted), len(substituted) + len(value)})
+		substituted = append(substituted, value...)
+		last = match[1]
+	}
+	if subs == nil {
+		return line, nil, nil
+	}
+	return string(append(substituted, line[last:]...)), subs, nil
+}
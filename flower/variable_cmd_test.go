@@ -0,0 +1,110 @@
+package flower
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_Variables(t *testing.T) {
+	os.Setenv("FLOWER_TEST_ENV", "production")
+	os.Setenv("FLOWER_TEST_PORT", "1")
+	defer os.Unsetenv("FLOWER_TEST_ENV")
+	defer os.Unsetenv("FLOWER_TEST_PORT")
+
+	interpreter := NewInterpreter(map[string]string{"web_port": "8080"})
+	interpreter.SetDryRun(true)
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		// from the environment
+		{"flower: web.${FLOWER_TEST_ENV}.corp offers http", "host:web.production.corp, port:80"},
+		// from the map passed to the interpreter
+		{"flower: web offers http:${web_port}", "host:web, port:8080"},
+		// defined in the document, which takes precedence over the environment
+		{"flower: set FLOWER_TEST_ENV = staging", "variable:FLOWER_TEST_ENV"},
+		{"flower: set web_port = 9090", "variable:web_port"},
+		{"flower: set db = db.${FLOWER_TEST_ENV}.corp", "variable:db"},
+		{"flower: web.${FLOWER_TEST_ENV}.corp uses postgres at ${db}", "host:db.staging.corp, port:5432"},
+		// but not over the map passed to the interpreter
+		{"flower: web offers http:${web_port}", "host:web, port:8080"},
+	}
+	for _, test := range tests {
+		cmd := interpreter.EvaluateCode(test.input)
+		if cmd == nil || len(cmd.String()) < len(test.expected) || cmd.String()[:len(test.expected)] != test.expected {
+			t.Errorf("Input %s, expected %s, got %v", test.input, test.expected, cmd)
+		}
+	}
+
+	// the renderer publishes String, so it leaves out values, which may be secret
+	if cmd := interpreter.EvaluateCode("flower: set password = hunter2"); strings.Contains(cmd.String(), "hunter2") {
+		t.Errorf("Expected the value to be left out, got %s", cmd)
+	}
+
+	interpreter.SetVariable("web_port", "7070")
+	if cmd := interpreter.EvaluateCode("flower: web offers http:${web_port}"); cmd.(*ServiceCommand).port != 7070 {
+		t.Errorf("Expected SetVariable to change the port, got %s", cmd)
+	}
+	results := interpreter.Results()
+	// values may be secret, so results keep the directive as written
+	if results[1].Directive != "flower: web offers http:${web_port}" {
+		t.Errorf("Expected the directive as written, got %s", results[1].Directive)
+	}
+	// definitions are not checks
+	if len(results) != 5 {
+		t.Errorf("Expected 5 results without the definitions, got %v", results)
+	}
+	for _, result := range results {
+		if strings.Contains(result.Directive, " set ") {
+			t.Errorf("Unexpected result for a definition %+v", result)
+		}
+	}
+	if verdict := interpreter.Verdict(DefaultPolicy); verdict.Count(OK) != 0 {
+		t.Errorf("Expected definitions not to be counted, got %+v", verdict)
+	}
+	if len(interpreter.Diagnostics()) != 0 {
+		t.Errorf("Unexpected diagnostics %v", interpreter.Diagnostics())
+	}
+}
+
+func Test_Variables_Undefined(t *testing.T) {
+	interpreter := NewInterpreter()
+	interpreter.SetDryRun(true)
+	cmd := interpreter.EvaluateCode("flower: web.${FLOWER_TEST_UNDEFINED}.corp offers http")
+	if _, ok := cmd.(*SyntaxErrorCommand); !ok {
+		t.Fatalf("Expected a syntax error, got %v", cmd)
+	}
+	diagnostics := interpreter.Diagnostics()
+	if len(diagnostics) != 1 || diagnostics[0].Reason != "undefined variable FLOWER_TEST_UNDEFINED" || diagnostics[0].Column != 13 {
+		t.Errorf("Unexpected diagnostics %v", diagnostics)
+	}
+
+	// only directives are substituted
+	if cmd := interpreter.EvaluateCode("echo ${FLOWER_TEST_UNDEFINED}"); cmd != nil {
+		t.Errorf("Expected other code to be left alone, got %v", cmd)
+	}
+}
+
+// Diagnostics point at the directive as written, not as substituted
+func Test_Variables_DiagnosticColumn(t *testing.T) {
+	interpreter := NewInterpreter(map[string]string{"host": "a.long.host.name", "port": "99999", "web": "80"})
+	interpreter.SetDryRun(true)
+	interpreter.EvaluateCode("flower: ${host} offers http:${port}")
+	interpreter.EvaluateCode("flower: ${host} offers http:${web} timeout 1x")
+
+	expected := []string{
+		"line 1, column 29: port out of range",
+		"line 2, column 44: invalid timeout",
+	}
+	diagnostics := interpreter.Diagnostics()
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("Expected %d diagnostics, got %v", len(expected), diagnostics)
+	}
+	for i, diagnostic := range diagnostics {
+		if !strings.HasPrefix(diagnostic.String(), expected[i]) || !strings.Contains(diagnostic.Directive, "${host}") {
+			t.Errorf("Expected %s, got %s in %s", expected[i], diagnostic, diagnostic.Directive)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package flower
+
+// Policy decides which results make a document fail as a whole. FAIL and
+// TIMEOUT always fail it, and commands that were not run never do
+type Policy struct {
+	// Commands that do not apply to this host neither pass nor fail. When
+	// false, a document with such a command fails
+	IgnoreNoMatch bool
+
+	// Commands that could not be checked because of an error fail the
+	// document. When false, errors are counted but otherwise ignored
+	ErrorIsFailure bool
+}
+
+// The policy used by most tools: a host only answers for the commands that
+// apply to it, and a broken directive is a broken specification
+var DefaultPolicy = Policy{IgnoreNoMatch: true, ErrorIsFailure: true}
+
+// Verdict is the outcome of a document as a whole
+type Verdict struct {
+	// The number of commands with each result, keyed by the result constants
+	Counts map[int]int
+
+	// The number of commands that do not apply to this host. These are also
+	// counted as NO_ANSWER in Counts
+	NoMatch int
+
+	// The total number of commands
+	Total int
+
+	// The results that made the document fail, in document order
+	Failures []Result
+
+	// Whether the document passed under the policy
+	Passed bool
+}
+
+// Return the verdict on a set of results under a policy
+func Evaluate(results []Result, policy Policy) Verdict {
+	verdict := Verdict{Counts: make(map[int]int), Total: len(results)}
+	for _, result := range results {
+		verdict.Counts[result.Result]++
+		if result.Match == NO_MATCH && result.Result == NO_ANSWER {
+			verdict.NoMatch++
+		}
+		if policy.fails(result) {
+			verdict.Failures = append(verdict.Failures, result)
+		}
+	}
+	verdict.Passed = len(verdict.Failures) == 0
+	return verdict
+}
+
+// Return true if a result makes the document fail under the policy
+func (policy Policy) fails(result Result) bool {
+	switch result.Result {
+	case OK, NOT_RUN:
+		return false
+	case ERROR:
+		return policy.ErrorIsFailure
+	case NO_ANSWER:
+		return !(policy.IgnoreNoMatch && result.Match == NO_MATCH)
+	}
+	return true
+}
+
+// Return the number of commands with a result
+func (verdict Verdict) Count(result int) int {
+	return verdict.Counts[result]
+}
+
+// Return the exit status for a tool that checked the document: 0 if it
+// passed, and 1 if it failed
+func (verdict Verdict) ExitStatus() int {
+	if verdict.Passed {
+		return 0
+	}
+	return 1
+}
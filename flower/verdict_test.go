@@ -0,0 +1,52 @@
+package flower
+
+import (
+	"testing"
+)
+
+func Test_Verdict_Counts(t *testing.T) {
+	verdict := reportInterpreter().Verdict(DefaultPolicy)
+	if verdict.Total != 5 || verdict.NoMatch != 1 {
+		t.Errorf("Unexpected totals %+v", verdict)
+	}
+	for _, result := range []int{OK, FAIL, TIMEOUT, NO_ANSWER, ERROR} {
+		if verdict.Count(result) != 1 {
+			t.Errorf("Expected 1 %s, got %d", ResultName(result), verdict.Count(result))
+		}
+	}
+	if verdict.Passed || verdict.ExitStatus() != 1 || len(verdict.Failures) != 3 {
+		t.Errorf("Expected FAIL, TIMEOUT and ERROR to fail, got %+v", verdict)
+	}
+}
+
+func Test_Verdict_Policy(t *testing.T) {
+	ok := Result{Match: MATCH_HOST, Result: OK}
+	noMatch := Result{Match: NO_MATCH, Result: NO_ANSWER}
+	noAnswer := Result{Match: MATCH_HOST, Result: NO_ANSWER}
+	failed := Result{Match: MATCH_HOST, Result: FAIL}
+	timeout := Result{Match: MATCH_CALLER, Result: TIMEOUT}
+	broken := Result{Result: ERROR}
+
+	tests := []struct {
+		results []Result
+		policy  Policy
+		passed  bool
+	}{
+		{[]Result{}, Policy{}, true},
+		{[]Result{ok}, Policy{}, true},
+		{[]Result{ok, noMatch}, Policy{IgnoreNoMatch: true}, true},
+		{[]Result{ok, noMatch}, Policy{}, false},
+		{[]Result{ok, noAnswer}, Policy{IgnoreNoMatch: true}, false},
+		{[]Result{ok, broken}, Policy{}, true},
+		{[]Result{ok, broken}, Policy{ErrorIsFailure: true}, false},
+		{[]Result{ok, failed}, DefaultPolicy, false},
+		{[]Result{ok, timeout}, DefaultPolicy, false},
+		{[]Result{ok, noMatch}, DefaultPolicy, true},
+	}
+	for i, test := range tests {
+		verdict := Evaluate(test.results, test.policy)
+		if verdict.Passed != test.passed {
+			t.Errorf("Test %d: expected passed %v, got %v with policy %+v", i, test.passed, verdict.Passed, test.policy)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+// Package watch keeps a Markdown document's flower checks up to date: it
+// re-reads the document when it changes, re-runs the checks on an interval,
+// and serves the rendered HTML, which refreshes itself when the results
+// change.
+//
+//	watcher := watch.NewWatcher("runbook.md")
+//	go watcher.Run(nil)
+//	http.ListenAndServe("127.0.0.1:8080", watcher)
+package watch
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/davidoram/blackfriday"
+	"github.com/davidoram/blackfriday/flower"
+)
+
+// Default time between runs of the checks
+const DEFAULT_INTERVAL = time.Minute
+
+// How often the document is looked at to see if it has changed
+const DEFAULT_POLL = time.Second
+
+// The most status changes kept for each check
+const MAX_HISTORY = 20
+
+// Default Markdown extensions used to parse the document
+const DEFAULT_EXTENSIONS = blackfriday.EXTENSION_NO_INTRA_EMPHASIS |
+	blackfriday.EXTENSION_TABLES |
+	blackfriday.EXTENSION_FENCED_CODE |
+	blackfriday.EXTENSION_AUTOLINK |
+	blackfriday.EXTENSION_STRIKETHROUGH |
+	blackfriday.EXTENSION_SPACE_HEADERS
+
+// Change records a check's result changing
+type Change struct {
+	// When the change was seen
+	Time time.Time
+
+	// The check's directive
+	Directive string
+
+	// The result before and after, see the flower result constants. Before
+	// is NOT_RUN when the check is first seen
+	From int
+	To   int
+}
+
+// Watcher renders a document and runs its checks, over and over. It serves
+// the latest page over HTTP; use NewWatcher to create one
+type Watcher struct {
+	filename   string
+	interval   time.Duration
+	poll       time.Duration
+	htmlFlags  int
+	extensions int
+
+	// Called with each new interpreter, before the document is rendered, so
+	// that it can be configured
+	configure func(interpreter *flower.StandardInterpreter)
+
+	lock    sync.RWMutex
+	page    []byte
+	version int
+	modTime time.Time
+	size    int64
+
+	// The last result of each check, and its changes, by directive
+	results map[string]int
+	history map[string][]Change
+	order   []string
+
+	// Channels told the version of each new page
+	subscribers map[chan int]bool
+
+	// The results of every run, for Prometheus
+	metrics *flower.Metrics
+}
+
+// Create a Watcher for a Markdown file
+func NewWatcher(filename string) *Watcher {
+	return &Watcher{
+		filename:    filename,
+		interval:    DEFAULT_INTERVAL,
+		poll:        DEFAULT_POLL,
+		htmlFlags:   blackfriday.HTML_USE_XHTML | blackfriday.HTML_FLOWER,
+		extensions:  DEFAULT_EXTENSIONS,
+		results:     make(map[string]int),
+		history:     make(map[string][]Change),
+		subscribers: make(map[chan int]bool),
+		metrics:     flower.NewMetrics(),
+	}
+}
+
+// Set the time between runs of the checks
+func (watcher *Watcher) SetInterval(interval time.Duration) {
+	watcher.interval = interval
+}
+
+// Set how often the document is looked at to see if it has changed
+func (watcher *Watcher) SetPoll(poll time.Duration) {
+	watcher.poll = poll
+}
+
+// Set the HtmlRenderer flags and Markdown extensions used to render the
+// document. HTML_FLOWER is always added, and HTML_COMPLETE_PAGE removed, as
+// the watcher completes the page itself
+func (watcher *Watcher) SetFlags(htmlFlags int, extensions int) {
+	watcher.htmlFlags = htmlFlags&^blackfriday.HTML_COMPLETE_PAGE | blackfriday.HTML_FLOWER
+	watcher.extensions = extensions
+}
+
+// Set a function to configure each interpreter before it is used, eg: to set
+// its prober, timeout or executors
+func (watcher *Watcher) SetConfigure(configure func(interpreter *flower.StandardInterpreter)) {
+	watcher.configure = configure
+}
+
+// Render the document and run its checks until stop is closed. The checks are
+// run every interval, and as soon as the document changes
+func (watcher *Watcher) Run(stop <-chan struct{}) {
+	watcher.Update()
+	checks := time.NewTicker(watcher.interval)
+	defer checks.Stop()
+	polls := time.NewTicker(watcher.poll)
+	defer polls.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-checks.C:
+			watcher.Update()
+		case <-polls.C:
+			if watcher.changed() {
+				watcher.Update()
+			}
+		}
+	}
+}
+
+// Return true if the document has changed since it was last read
+func (watcher *Watcher) changed() bool {
+	info, err := os.Stat(watcher.filename)
+	if err != nil {
+		return false
+	}
+	watcher.lock.RLock()
+	defer watcher.lock.RUnlock()
+	return !info.ModTime().Equal(watcher.modTime) || info.Size() != watcher.size
+}
+
+// Read and render the document, running its checks, then record the changes
+// in their results and tell the pages being viewed
+func (watcher *Watcher) Update() error {
+	info, err := os.Stat(watcher.filename)
+	if err != nil {
+		return watcher.fail(err)
+	}
+	input, err := ioutil.ReadFile(watcher.filename)
+	if err != nil {
+		return watcher.fail(err)
+	}
+
+	interpreter := flower.NewInterpreter()
+	if watcher.configure != nil {
+		watcher.configure(interpreter)
+	}
+	renderer := blackfriday.FlowerRenderer(blackfriday.HtmlRenderer(watcher.htmlFlags, "", ""), interpreter)
+	body := blackfriday.Markdown(input, renderer, watcher.extensions)
+	now := time.Now()
+
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+	watcher.modTime = info.ModTime()
+	watcher.size = info.Size()
+	results := interpreter.Results()
+	for _, result := range results {
+		watcher.record(result, now)
+	}
+	watcher.metrics.Observe(results)
+
+	var page bytes.Buffer
+	page.Write(body)
+	page.Write(interpreter.SummaryReport())
+	watcher.writeHistory(&page)
+	watcher.publish(page.Bytes())
+	return nil
+}
+
+// Show an error in place of the document, so that it is seen on the display
+func (watcher *Watcher) fail(err error) error {
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+	var page bytes.Buffer
+	page.WriteString("<!DOCTYPE html>\n<html>\n<head>\n</head>\n<body>\n")
+	fmt.Fprintf(&page, "<p class=\"FLOWER-ERROR\">%s</p>\n", html.EscapeString(err.Error()))
+	watcher.publish(page.Bytes())
+	return err
+}
+
+// Record a check's result, if it has changed
+func (watcher *Watcher) record(result flower.Result, now time.Time) {
+	if result.Directive == "" {
+		return
+	}
+	last, ok := watcher.results[result.Directive]
+	if !ok {
+		last = flower.NOT_RUN
+		watcher.order = append(watcher.order, result.Directive)
+	}
+	watcher.results[result.Directive] = result.Result
+	if ok && last == result.Result {
+		return
+	}
+	changes := append(watcher.history[result.Directive], Change{now, result.Directive, last, result.Result})
+	if len(changes) > MAX_HISTORY {
+		changes = changes[len(changes)-MAX_HISTORY:]
+	}
+	watcher.history[result.Directive] = changes
+}
+
+// Return the changes to a check's result, oldest first
+func (watcher *Watcher) History(directive string) []Change {
+	watcher.lock.RLock()
+	defer watcher.lock.RUnlock()
+	return append([]Change(nil), watcher.history[directive]...)
+}
+
+// Write a table of the changes to each check's result, newest first
+func (watcher *Watcher) writeHistory(out *bytes.Buffer) {
+	out.WriteString("<table class=\"FLOWER-HISTORY\"><tr><th colspan=\"3\">History</th></tr>\n")
+	for _, directive := range watcher.order {
+		changes := watcher.history[directive]
+		fmt.Fprintf(out, "<tr><th colspan=\"3\">%s</th></tr>\n", html.EscapeString(directive))
+		for i := len(changes) - 1; i >= 0; i-- {
+			change := changes[i]
+			fmt.Fprintf(out, "<tr><td>%s</td><td>%s</td><td class=\"FLOWER-%s\">%s</td></tr>\n",
+				change.Time.Format(time.RFC3339), flower.ResultName(change.From), resultClass(change.To), flower.ResultName(change.To))
+		}
+	}
+	out.WriteString("</table>\n")
+}
+
+// Return the class of a result, eg: NOT_RUN is NOT-RUN
+func resultClass(result int) string {
+	return string(bytes.Replace([]byte(flower.ResultName(result)), []byte("_"), []byte("-"), -1))
+}
+
+// Make a page the latest, and tell the subscribers. Must be called with the
+// lock held
+func (watcher *Watcher) publish(page []byte) {
+	watcher.version++
+	watcher.page = page
+	for subscriber := range watcher.subscribers {
+		select {
+		case subscriber <- watcher.version:
+		default:
+			// the subscriber has not read the last version yet, it will
+			// reload the latest page when it does
+		}
+	}
+}
+
+// Return the latest page and its version
+func (watcher *Watcher) Page() ([]byte, int) {
+	watcher.lock.RLock()
+	defer watcher.lock.RUnlock()
+	return watcher.page, watcher.version
+}
+
+// Return the metrics of the checks, which are updated after each run
+func (watcher *Watcher) Metrics() *flower.Metrics {
+	return watcher.metrics
+}
+
+// Serve the latest page at /, its version at /version for pages that poll,
+// server-sent events with each new version at /events, and the metrics of the
+// checks for Prometheus at /metrics
+func (watcher *Watcher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/":
+		page, version := watcher.Page()
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(page)
+		fmt.Fprintf(w, refreshScript, version)
+		w.Write([]byte("</body>\n</html>\n"))
+	case "/version":
+		_, version := watcher.Page()
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(strconv.Itoa(version)))
+	case "/events":
+		watcher.serveEvents(w, r)
+	case "/metrics":
+		watcher.metrics.ServeHTTP(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Send an event with the version of each new page, until the client goes away
+func (watcher *Watcher) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	versions := make(chan int, 1)
+	watcher.lock.Lock()
+	watcher.subscribers[versions] = true
+	watcher.lock.Unlock()
+	defer func() {
+		watcher.lock.Lock()
+		delete(watcher.subscribers, versions)
+		watcher.lock.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case version := <-versions:
+			fmt.Fprintf(w, "data: %d\n\n", version)
+			flusher.Flush()
+		}
+	}
+}
+
+// Reloads the page when there is a newer version, using server-sent events
+// where the browser supports them and polling where it does not
+const refreshScript = `<script>
+var version = %d;
+function reload(latest) { if (parseInt(latest) > version) { location.reload(); } }
+if (window.EventSource) {
+  new EventSource("/events").onmessage = function(event) { reload(event.data); };
+} else {
+  setInterval(function() {
+    var request = new XMLHttpRequest();
+    request.onload = function() { reload(request.responseText); };
+    request.open("GET", "/version");
+    request.send();
+  }, 5000);
+}
+</script>
+`
@@ -0,0 +1,180 @@
+package watch
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davidoram/blackfriday/flower"
+)
+
+// Return a watcher for a document in a temporary directory, whose checks use
+// prober
+func testWatcher(t *testing.T, document string, prober flower.Prober) (*Watcher, string) {
+	dir, err := ioutil.TempDir("", "watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "runbook.md")
+	if err := ioutil.WriteFile(filename, []byte(document), 0644); err != nil {
+		t.Fatal(err)
+	}
+	watcher := NewWatcher(filename)
+	watcher.SetConfigure(func(interpreter *flower.StandardInterpreter) {
+		interpreter.SetProber(prober)
+	})
+	return watcher, filename
+}
+
+func testProber() *flower.ScriptedProber {
+	prober := flower.NewScriptedProber()
+	prober.AddLocal("10.0.0.1")
+	prober.AddHost("web", "10.0.0.1")
+	return prober
+}
+
+func Test_Update(t *testing.T) {
+	closed := testProber()
+	watcher, filename := testWatcher(t, "# Runbook\n\n    flower: web offers http\n", closed)
+	defer os.RemoveAll(filepath.Dir(filename))
+
+	if err := watcher.Update(); err != nil {
+		t.Fatalf("Update returned error: %v", err)
+	}
+	page, version := watcher.Page()
+	if version != 1 || !strings.Contains(string(page), "FLOWER-FAIL") || !strings.Contains(string(page), "<h1>Runbook</h1>") {
+		t.Errorf("Unexpected page %d %s", version, page)
+	}
+
+	open := testProber()
+	open.Open("tcp", "10.0.0.1:80")
+	watcher.SetConfigure(func(interpreter *flower.StandardInterpreter) {
+		interpreter.SetProber(open)
+	})
+	watcher.Update()
+	watcher.Update()
+
+	history := watcher.History("flower: web offers http")
+	if len(history) != 2 {
+		t.Fatalf("Expected 2 changes, got %v", history)
+	}
+	if history[0].From != flower.NOT_RUN || history[0].To != flower.FAIL || history[1].From != flower.FAIL || history[1].To != flower.OK {
+		t.Errorf("Unexpected history %v", history)
+	}
+	page, version = watcher.Page()
+	if version != 3 || !strings.Contains(string(page), "<td>FAIL</td><td class=\"FLOWER-OK\">OK</td>") {
+		t.Errorf("Expected the history in the page, got %d %s", version, page)
+	}
+
+	// a changed document is read again
+	ioutil.WriteFile(filename, []byte("# Changed runbook\n\n    flower: web offers http:81\n"), 0644)
+	watcher.Update()
+	page, _ = watcher.Page()
+	if !strings.Contains(string(page), "Changed runbook") || len(watcher.History("flower: web offers http:81")) != 1 {
+		t.Errorf("Expected the changed document, got %s", page)
+	}
+
+	os.Remove(filename)
+	if err := watcher.Update(); err == nil {
+		t.Errorf("Expected an error for a missing document")
+	}
+	page, _ = watcher.Page()
+	if !strings.Contains(string(page), "FLOWER-ERROR") {
+		t.Errorf("Expected the error in the page, got %s", page)
+	}
+}
+
+func Test_Run(t *testing.T) {
+	watcher, filename := testWatcher(t, "    flower: web offers http\n", testProber())
+	defer os.RemoveAll(filepath.Dir(filename))
+	watcher.SetInterval(time.Hour)
+	watcher.SetPoll(5 * time.Millisecond)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		watcher.Run(stop)
+		close(done)
+	}()
+
+	waitFor := func(version int) {
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+			if _, v := watcher.Page(); v >= version {
+				return
+			}
+		}
+		t.Fatalf("Timed out waiting for version %d", version)
+	}
+	waitFor(1)
+
+	// make sure the modification time differs on coarse file systems
+	later := time.Now().Add(time.Second)
+	ioutil.WriteFile(filename, []byte("    flower: web offers https\n"), 0644)
+	os.Chtimes(filename, later, later)
+	waitFor(2)
+	close(stop)
+	<-done
+
+	page, _ := watcher.Page()
+	if !strings.Contains(string(page), "flower: web offers https") {
+		t.Errorf("Expected the changed document, got %s", page)
+	}
+}
+
+func Test_ServeHTTP(t *testing.T) {
+	watcher, filename := testWatcher(t, "    flower: web offers http\n", testProber())
+	defer os.RemoveAll(filepath.Dir(filename))
+	watcher.Update()
+	server := httptest.NewServer(watcher)
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if !strings.Contains(string(body), "var version = 1;") || !strings.HasSuffix(string(body), "</body>\n</html>\n") {
+		t.Errorf("Unexpected page %s", body)
+	}
+
+	response, err = http.Get(server.URL + "/version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if string(body) != "1" {
+		t.Errorf("Expected version 1, got %s", body)
+	}
+
+	response, err = http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if !strings.Contains(string(body), `flower_check_up{host="web",service="http",port="80",caller="web"} 0`) {
+		t.Errorf("Expected the metrics, got %s", body)
+	}
+
+	response, err = http.Get(server.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("Unexpected content type %s", response.Header.Get("Content-Type"))
+	}
+	watcher.Update()
+	line, err := bufio.NewReader(response.Body).ReadString('\n')
+	if err != nil || line != "data: 2\n" {
+		t.Errorf("Expected an event for version 2, got %q %v", line, err)
+	}
+}
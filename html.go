@@ -40,6 +40,7 @@ const (
 	HTML_SMARTYPANTS_FRACTIONS                // enable smart fractions (with HTML_USE_SMARTYPANTS)
 	HTML_SMARTYPANTS_LATEX_DASHES             // enable LaTeX-style dashes (with HTML_USE_SMARTYPANTS)
 	HTML_FLOWER             				  // enable Flower processing
+	HTML_SOURCEPOS                            // add data-sourcepos attributes to block elements
 )
 
 // Html is a type that implements the Renderer interface for HTML output.
@@ -50,6 +51,7 @@ type Html struct {
 	closeTag string // how to end singleton tags: either " />\n" or ">\n"
 	title    string // document title
 	css      string // optional css file url (used with HTML_COMPLETE_PAGE)
+	safeLink func(link []byte) bool
 
 	// table of contents data
 	tocMarker    int
@@ -57,6 +59,9 @@ type Html struct {
 	currentLevel int
 	toc          *bytes.Buffer
 
+	// where the element being rendered came from (used with HTML_SOURCEPOS)
+	start, end Position
+
 	smartypants *smartypantsRenderer
 }
 
@@ -73,17 +78,43 @@ const (
 // stylesheet.
 // title and css are only used when HTML_COMPLETE_PAGE is selected.
 func HtmlRenderer(flags int, title string, css string) Renderer {
+	return HtmlRendererWithOptions(HtmlOptions{Flags: flags, Title: title, CSS: css})
+}
+
+// HtmlOptions configures the HTML renderer. Fields left at zero get their
+// defaults.
+type HtmlOptions struct {
+	Flags int    // HTML_* options ORed together
+	Title string // title of the document (used with HTML_COMPLETE_PAGE)
+	CSS   string // optional css file url (used with HTML_COMPLETE_PAGE)
+
+	// SafeLink decides which links are written out as links when
+	// HTML_SAFELINK is set; the others are written out as text. By default
+	// only links that start with http://, https://, ftp:// or mailto:// are
+	// allowed.
+	SafeLink func(link []byte) bool
+}
+
+// HtmlRendererWithOptions is like HtmlRenderer, but takes all of the
+// renderer's settings.
+func HtmlRendererWithOptions(options HtmlOptions) Renderer {
 	// configure the rendering engine
+	flags := options.Flags
 	closeTag := htmlClose
 	if flags&HTML_USE_XHTML != 0 {
 		closeTag = xhtmlClose
 	}
+	safeLink := options.SafeLink
+	if safeLink == nil {
+		safeLink = isSafeLink
+	}
 
 	return &Html{
 		flags:    flags,
 		closeTag: closeTag,
-		title:    title,
-		css:      css,
+		title:    options.Title,
+		css:      options.CSS,
+		safeLink: safeLink,
 
 		headerCount:  0,
 		currentLevel: 0,
@@ -93,15 +124,50 @@ func HtmlRenderer(flags int, title string, css string) Renderer {
 	}
 }
 
+// SourcePos records where the next element came from, so that it can be
+// written out as a data-sourcepos attribute when HTML_SOURCEPOS is set.
+func (options *Html) SourcePos(start, end Position) {
+	options.start, options.end = start, end
+}
+
+// Positions are only written out with HTML_SOURCEPOS
+func (options *Html) wantsSourcePos() bool {
+	return options.flags&HTML_SOURCEPOS != 0
+}
+
+// Write the data-sourcepos attribute of the element being rendered, if it
+// has one. The position is used up, so that tags written for the element's
+// children don't claim it too.
+func (options *Html) sourcePos(out *bytes.Buffer) {
+	if options.flags&HTML_SOURCEPOS == 0 || !options.start.IsValid() {
+		return
+	}
+	out.WriteString(" data-sourcepos=\"")
+	out.WriteString(options.start.String())
+	out.WriteByte('-')
+	out.WriteString(options.end.String())
+	out.WriteByte('"')
+	options.start, options.end = Position{}, Position{}
+}
+
 // Surround HTML code with tags that can be used to identify and style the flower command contained within
 func (options *Html) CommandTagStart(out *bytes.Buffer, command flower.Command) {
 	if command != nil {
-		out.WriteString("<div class=\""  + command.HtmlClass() + "\" title=\"" + command.String() + "\" >")
+		out.WriteString("<div class=\"")
+		attrEscape(out, []byte(command.HtmlClass()))
+		out.WriteString("\" title=\"")
+		attrEscape(out, []byte(command.String()))
+		out.WriteString("\" >")
 	}
 }
 
 func (options *Html) CommandTagEnd(out *bytes.Buffer, command flower.Command) {
 	if command != nil {
+		if syntaxError, ok := command.(*flower.SyntaxErrorCommand); ok {
+			out.WriteString("<span class=\"FLOWER-DIAGNOSTIC\"> &larr; ")
+			attrEscape(out, []byte(syntaxError.Diagnostic().String()))
+			out.WriteString("</span>")
+		}
 		out.WriteString("</div>")
 	}
 }
@@ -159,9 +225,13 @@ func (options *Html) Header(out *bytes.Buffer, text func() bool, level int) {
 
 	if options.flags&HTML_TOC != 0 {
 		// headerCount is incremented in htmlTocHeader
-		out.WriteString(fmt.Sprintf("<h%d id=\"toc_%d\">", level, options.headerCount))
+		out.WriteString(fmt.Sprintf("<h%d", level))
+		options.sourcePos(out)
+		out.WriteString(fmt.Sprintf(" id=\"toc_%d\">", options.headerCount))
 	} else {
-		out.WriteString(fmt.Sprintf("<h%d>", level))
+		out.WriteString(fmt.Sprintf("<h%d", level))
+		options.sourcePos(out)
+		out.WriteByte('>')
 	}
 
 	tocMarker := out.Len()
@@ -213,6 +283,7 @@ func stripTag(text, tag, newTag string) []byte {
 func (options *Html) HRule(out *bytes.Buffer) {
 	doubleSpace(out)
 	out.WriteString("<hr")
+	options.sourcePos(out)
 	out.WriteString(options.closeTag)
 }
 
@@ -245,7 +316,9 @@ func (options *Html) BlockCodeNormalStart(out *bytes.Buffer, text []byte, lang s
 			continue
 		}
 		if count == 0 {
-			out.WriteString("<pre><code class=\"")
+			out.WriteString("<pre")
+			options.sourcePos(out)
+			out.WriteString("><code class=\"")
 		} else {
 			out.WriteByte(' ')
 		}
@@ -254,7 +327,9 @@ func (options *Html) BlockCodeNormalStart(out *bytes.Buffer, text []byte, lang s
 	}
 
 	if count == 0 {
-		out.WriteString("<pre><code>")
+		out.WriteString("<pre")
+		options.sourcePos(out)
+		out.WriteString("><code>")
 	} else {
 		out.WriteString("\">")
 	}
@@ -289,7 +364,9 @@ func (options *Html) BlockCodeGithubStart(out *bytes.Buffer, text []byte, lang s
 		if len(elt) == 0 {
 			continue
 		}
-		out.WriteString("<pre lang=\"")
+		out.WriteString("<pre")
+		options.sourcePos(out)
+		out.WriteString(" lang=\"")
 		attrEscape(out, []byte(elt))
 		out.WriteString("\"><code>")
 		count++
@@ -297,20 +374,26 @@ func (options *Html) BlockCodeGithubStart(out *bytes.Buffer, text []byte, lang s
 	}
 
 	if count == 0 {
-		out.WriteString("<pre><code>")
+		out.WriteString("<pre")
+		options.sourcePos(out)
+		out.WriteString("><code>")
 	}
 }
 
 func (options *Html) BlockQuote(out *bytes.Buffer, text []byte) {
 	doubleSpace(out)
-	out.WriteString("<blockquote>\n")
+	out.WriteString("<blockquote")
+	options.sourcePos(out)
+	out.WriteString(">\n")
 	out.Write(text)
 	out.WriteString("</blockquote>\n")
 }
 
 func (options *Html) Table(out *bytes.Buffer, header []byte, body []byte, columnData []int) {
 	doubleSpace(out)
-	out.WriteString("<table>\n<thead>\n")
+	out.WriteString("<table")
+	options.sourcePos(out)
+	out.WriteString(">\n<thead>\n")
 	out.Write(header)
 	out.WriteString("</thead>\n\n<tbody>\n")
 	out.Write(body)
@@ -319,22 +402,26 @@ func (options *Html) Table(out *bytes.Buffer, header []byte, body []byte, column
 
 func (options *Html) TableRow(out *bytes.Buffer, text []byte) {
 	doubleSpace(out)
-	out.WriteString("<tr>\n")
+	out.WriteString("<tr")
+	options.sourcePos(out)
+	out.WriteString(">\n")
 	out.Write(text)
 	out.WriteString("\n</tr>\n")
 }
 
 func (options *Html) TableCell(out *bytes.Buffer, text []byte, align int) {
 	doubleSpace(out)
+	out.WriteString("<td")
+	options.sourcePos(out)
 	switch align {
 	case TABLE_ALIGNMENT_LEFT:
-		out.WriteString("<td align=\"left\">")
+		out.WriteString(" align=\"left\">")
 	case TABLE_ALIGNMENT_RIGHT:
-		out.WriteString("<td align=\"right\">")
+		out.WriteString(" align=\"right\">")
 	case TABLE_ALIGNMENT_CENTER:
-		out.WriteString("<td align=\"center\">")
+		out.WriteString(" align=\"center\">")
 	default:
-		out.WriteString("<td>")
+		out.WriteString(">")
 	}
 
 	out.Write(text)
@@ -342,6 +429,8 @@ func (options *Html) TableCell(out *bytes.Buffer, text []byte, align int) {
 }
 
 func (options *Html) Footnotes(out *bytes.Buffer, text func() bool) {
+	// the rule and list belong to the footnotes, not to the input
+	options.SourcePos(Position{}, Position{})
 	out.WriteString("<div class=\"footnotes\">\n")
 	options.HRule(out)
 	options.List(out, text, LIST_TYPE_ORDERED)
@@ -364,10 +453,12 @@ func (options *Html) List(out *bytes.Buffer, text func() bool, flags int) {
 	doubleSpace(out)
 
 	if flags&LIST_TYPE_ORDERED != 0 {
-		out.WriteString("<ol>")
+		out.WriteString("<ol")
 	} else {
-		out.WriteString("<ul>")
+		out.WriteString("<ul")
 	}
+	options.sourcePos(out)
+	out.WriteByte('>')
 	if !text() {
 		out.Truncate(marker)
 		return
@@ -383,7 +474,9 @@ func (options *Html) ListItem(out *bytes.Buffer, text []byte, flags int) {
 	if flags&LIST_ITEM_CONTAINS_BLOCK != 0 || flags&LIST_ITEM_BEGINNING_OF_LIST != 0 {
 		doubleSpace(out)
 	}
-	out.WriteString("<li>")
+	out.WriteString("<li")
+	options.sourcePos(out)
+	out.WriteByte('>')
 	out.Write(text)
 	out.WriteString("</li>\n")
 }
@@ -392,7 +485,9 @@ func (options *Html) Paragraph(out *bytes.Buffer, text func() bool) {
 	marker := out.Len()
 	doubleSpace(out)
 
-	out.WriteString("<p>")
+	out.WriteString("<p")
+	options.sourcePos(out)
+	out.WriteByte('>')
 	if !text() {
 		out.Truncate(marker)
 		return
@@ -401,7 +496,7 @@ func (options *Html) Paragraph(out *bytes.Buffer, text func() bool) {
 }
 
 func (options *Html) AutoLink(out *bytes.Buffer, link []byte, kind int) {
-	if options.flags&HTML_SAFELINK != 0 && !isSafeLink(link) && kind != LINK_TYPE_EMAIL {
+	if options.flags&HTML_SAFELINK != 0 && !options.safeLink(link) && kind != LINK_TYPE_EMAIL {
 		// mark it but don't link it if it is not a safe link: no smartypants
 		out.WriteString("<tt>")
 		attrEscape(out, link)
@@ -493,7 +588,7 @@ func (options *Html) Link(out *bytes.Buffer, link []byte, title []byte, content
 		return
 	}
 
-	if options.flags&HTML_SAFELINK != 0 && !isSafeLink(link) {
+	if options.flags&HTML_SAFELINK != 0 && !options.safeLink(link) {
 		// write the link text out but don't link it, just mark it with typewriter font
 		out.WriteString("<tt>")
 		attrEscape(out, content)
@@ -652,9 +747,22 @@ func (options *Html) FlowerCSS(out *bytes.Buffer) {
 	out.WriteString(".FLOWER-FAIL { color:red; }\n")
 	out.WriteString(".FLOWER-OK { color:green; }\n")
 	out.WriteString(".FLOWER-ERROR { background: red; color:green; }\n")
+	out.WriteString(".FLOWER-TIMEOUT { color:orange; }\n")
+	out.WriteString(".FLOWER-NOT-RUN { color:grey; }\n")
+	out.WriteString(".FLOWER-VARIABLE { color:grey; font-style:italic; }\n")
+	out.WriteString(".FLOWER-SYNTAX { text-decoration: red wavy underline; }\n")
+	out.WriteString(".FLOWER-DIAGNOSTIC { color:red; font-style:italic; }\n")
+	out.WriteString(".FLOWER-REGRESSED, .FLOWER-REMOVED { color:red; }\n")
+	out.WriteString(".FLOWER-SLOWER { color:orange; }\n")
 	out.WriteString("</style>\n")
 }
 
+// Streaming reports whether the output written so far is final, which it
+// isn't when the table of contents is still to be inserted before it.
+func (options *Html) Streaming() bool {
+	return options.flags&HTML_TOC == 0
+}
+
 func (options *Html) DocumentFooter(out *bytes.Buffer) {
 	// finalize and insert the table of contents
 	if options.flags&HTML_TOC != 0 {
@@ -25,10 +25,11 @@ import (
 
 func (p *parser) inline(out *bytes.Buffer, data []byte) {
 	// this is called recursively: enforce a maximum depth
-	if p.nesting >= p.maxNesting {
+	if p.nesting >= p.maxNesting || p.skipInline {
 		return
 	}
 	p.nesting++
+	delimiters := len(p.delimiters)
 
 	i, end := 0, 0
 	for i < len(data) {
@@ -56,6 +57,13 @@ func (p *parser) inline(out *bytes.Buffer, data []byte) {
 		}
 	}
 
+	// CommonMark emphasis can only be worked out once the runs of '*' and
+	// '_' around it are all known
+	if len(p.delimiters) > delimiters {
+		p.matchDelimiters(out, data, p.delimiters[delimiters:])
+		p.delimiters = p.delimiters[:delimiters]
+	}
+
 	p.nesting--
 }
 
@@ -141,6 +149,7 @@ func codeSpan(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 
 	// render the code span
 	if fBegin != fEnd {
+		p.at(data, 0, end)
 		p.r.CodeSpanStart(out, data[fBegin:fEnd])
 		p.r.CodeSpanBody(out, data[fBegin:fEnd])
 		p.r.CodeSpanEnd(out, data[fBegin:fEnd])
@@ -167,6 +176,7 @@ func lineBreak(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 		return 0
 	}
 
+	p.at(data, offset, offset+1)
 	p.r.LineBreak(out)
 	return 1
 }
@@ -379,7 +389,7 @@ func link(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 		}
 
 		// find the reference with matching id (ids are case-insensitive)
-		key := string(bytes.ToLower(id))
+		key := p.referenceKey(id)
 		lr, ok := p.refs[key]
 		if !ok {
 			return 0
@@ -417,7 +427,7 @@ func link(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 			}
 		}
 
-		key := string(bytes.ToLower(id))
+		key := p.referenceKey(id)
 		if t == linkInlineFootnote {
 			// create a new reference
 			noteId = len(p.notes) + 1
@@ -499,6 +509,7 @@ func link(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 	// call the relevant rendering function
 	switch t {
 	case linkNormal:
+		p.at(data, 0, i)
 		p.r.Link(out, uLink, title, content.Bytes())
 
 	case linkImg:
@@ -508,6 +519,7 @@ func link(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 			out.Truncate(outSize - 1)
 		}
 
+		p.at(data, -1, i)
 		p.r.Image(out, uLink, title, content.Bytes())
 
 	case linkInlineFootnote:
@@ -517,9 +529,11 @@ func link(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 			out.Truncate(outSize - 1)
 		}
 
+		p.at(data, -1, i)
 		p.r.FootnoteRef(out, link, noteId)
 
 	case linkDeferredFootnote:
+		p.at(data, 0, i)
 		p.r.FootnoteRef(out, link, noteId)
 
 	default:
@@ -540,9 +554,11 @@ func leftAngle(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 			var uLink bytes.Buffer
 			unescapeText(&uLink, data[1:end+1-2])
 			if uLink.Len() > 0 {
+				p.at(data, 0, end)
 				p.r.AutoLink(out, uLink.Bytes(), altype)
 			}
 		} else {
+			p.at(data, 0, end)
 			p.r.RawHtmlTag(out, data[:end])
 		}
 	}
@@ -609,6 +625,7 @@ func entity(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 		return 0 // lone '&'
 	}
 
+	p.at(data, 0, end)
 	p.r.Entity(out, data[:end])
 
 	return end
@@ -706,14 +723,13 @@ func autoLink(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 	}
 
 	// we were triggered on the ':', so we need to rewind the output a bit
-	if out.Len() >= rewind {
-		out.Truncate(len(out.Bytes()) - rewind)
-	}
+	rewindText(out, rewind)
 
 	var uLink bytes.Buffer
 	unescapeText(&uLink, data[:linkEnd])
 
 	if uLink.Len() > 0 {
+		p.at(data, 0, linkEnd)
 		p.r.AutoLink(out, uLink.Bytes(), LINK_TYPE_NORMAL)
 	}
 
@@ -957,6 +973,7 @@ func helperEmphasis(p *parser, out *bytes.Buffer, data []byte, c byte) int {
 
 			var work bytes.Buffer
 			p.inline(&work, data[:i])
+			p.at(data, -1, i+1)
 			p.r.Emphasis(out, work.Bytes())
 			return i + 1
 		}
@@ -980,6 +997,8 @@ func helperDoubleEmphasis(p *parser, out *bytes.Buffer, data []byte, c byte) int
 			p.inline(&work, data[:i])
 
 			if work.Len() > 0 {
+				p.at(data, -2, i+2)
+
 				// pick the right renderer
 				if c == '~' {
 					p.r.StrikeThrough(out, work.Bytes())
@@ -1018,6 +1037,7 @@ func helperTripleEmphasis(p *parser, out *bytes.Buffer, data []byte, offset int,
 
 			p.inline(&work, data[:i])
 			if work.Len() > 0 {
+				p.at(data, -3, i+3)
 				p.r.TripleEmphasis(out, work.Bytes())
 			}
 			return i + 3
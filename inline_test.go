@@ -14,6 +14,7 @@
 package blackfriday
 
 import (
+	"bytes"
 	"testing"
 )
 
@@ -687,3 +688,33 @@ what happens here
 
 	doTestsInlineParam(t, tests, EXTENSION_FOOTNOTES, 0)
 }
+
+func TestSafeLinkOption(t *testing.T) {
+	input := []byte("[a](http://example.com/) [b](/local) <http://example.org/>\n")
+
+	// the default policy only allows full URLs with known protocols
+	renderer := HtmlRendererWithOptions(HtmlOptions{Flags: HTML_SAFELINK})
+	expected := "<p><a href=\"http://example.com/\">a</a> <tt>b</tt> <a href=\"http://example.org/\">http://example.org/</a></p>\n"
+	if actual := string(Markdown(input, renderer, 0)); actual != expected {
+		t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", string(input), expected, actual)
+	}
+
+	// a policy of our own
+	renderer = HtmlRendererWithOptions(HtmlOptions{
+		Flags: HTML_SAFELINK,
+		SafeLink: func(link []byte) bool {
+			return bytes.HasPrefix(link, []byte("/")) || bytes.HasPrefix(link, []byte("http://example.com/"))
+		},
+	})
+	expected = "<p><a href=\"http://example.com/\">a</a> <a href=\"/local\">b</a> <tt>http://example.org/</tt></p>\n"
+	if actual := string(Markdown(input, renderer, 0)); actual != expected {
+		t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", string(input), expected, actual)
+	}
+
+	// the policy only applies with HTML_SAFELINK
+	renderer = HtmlRendererWithOptions(HtmlOptions{SafeLink: func([]byte) bool { return false }})
+	expected = "<p><a href=\"http://example.com/\">a</a> <a href=\"/local\">b</a> <a href=\"http://example.org/\">http://example.org/</a></p>\n"
+	if actual := string(Markdown(input, renderer, 0)); actual != expected {
+		t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", string(input), expected, actual)
+	}
+}
@@ -305,6 +305,11 @@ func (options *Latex) NormalText(out *bytes.Buffer, text []byte) {
 	escapeSpecialChars(out, text)
 }
 
+// the output is never rewritten, so it can be streamed
+func (options *Latex) Streaming() bool {
+	return true
+}
+
 // header and footer
 func (options *Latex) DocumentHeader(out *bytes.Buffer) {
 	out.WriteString("\\documentclass{article}\n")
@@ -40,6 +40,7 @@ const (
 	EXTENSION_TAB_SIZE_EIGHT                         // expand tabs to eight spaces instead of four
 	EXTENSION_FOOTNOTES                              // Pandoc-style footnotes
 	EXTENSION_NO_EMPTY_LINE_BEFORE_BLOCK             // No need to insert an empty line to start a (code, quote, order list, unorder list)block
+	EXTENSION_COMMONMARK                             // follow the CommonMark spec where it differs from Markdown 1.0.3
 )
 
 // These are the possible flag values for the link renderer.
@@ -76,6 +77,10 @@ const (
 	TAB_SIZE_EIGHT   = 8
 )
 
+// How deeply blocks and spans can be nested, unless ParserOptions says
+// otherwise.
+const MAX_NESTING_DEFAULT = 16
+
 // These are the tags that are recognized as HTML block tags.
 // Any of these can be included in markdown text without special escaping.
 var blockTags = map[string]bool{
@@ -181,7 +186,7 @@ type Renderer interface {
 type inlineParser func(p *parser, out *bytes.Buffer, data []byte, offset int) int
 
 // Parser holds runtime state used by the parser.
-// This is constructed by the Markdown function.
+// This is constructed by the newParser function.
 type parser struct {
 	r              Renderer
 	refs           map[string]*reference
@@ -189,12 +194,39 @@ type parser struct {
 	flags          int
 	nesting        int
 	maxNesting     int
+	tabSize        int
 	insideLink     bool
 
 	// Footnotes need to be ordered as well as available to quickly check for
 	// presence. If a ref is also a footnote, it's stored both in refs and here
 	// in notes. Slice is nil if footnotes not enabled.
 	notes []*reference
+
+	// Where the text being parsed came from, the input offsets of the first
+	// and last bytes of the next element handed to the renderer, and of the
+	// start of the next code block's text. These are only kept track of when
+	// positions is set, as it costs time
+	positions bool
+	sources   []source
+	beg, last int
+	codeBeg   int
+
+	// CommonMark: runs of '*' and '_' waiting to be matched up, whether only
+	// the blocks are being looked at, the nesting level of the paragraphs
+	// that go without tags in tight list items, and the nesting level whose
+	// blank lines make the list loose (and whether any were found), along
+	// with the lists already known to be loose or tight
+	delimiters   []delimiter
+	skipInline   bool
+	tightNesting int
+	looseNesting int
+	loose        bool
+	looseLists   map[int]bool
+
+	// Called with the document's output between its top-level blocks, so
+	// that they can be rendered as they are parsed (see Render)
+	flush    func(out *bytes.Buffer)
+	document *bytes.Buffer
 }
 
 //
@@ -263,18 +295,68 @@ func MarkdownCommon(input []byte) []byte {
 // To use the supplied Html or LaTeX renderers, see HtmlRenderer and
 // LatexRenderer, respectively.
 func Markdown(input []byte, renderer Renderer, extensions int) []byte {
+	return MarkdownWithOptions(input, renderer, ParserOptions{Extensions: extensions})
+}
+
+// ParserOptions configures the parser. Fields left at zero get their
+// defaults, so ParserOptions{Extensions: extensions} parses the same way as
+// passing extensions on its own.
+type ParserOptions struct {
+	Extensions int // EXTENSION_* options ORed together
+	MaxNesting int // how deeply blocks and spans can be nested (MAX_NESTING_DEFAULT)
+	TabSize    int // columns between tab stops (TAB_SIZE_DEFAULT, or TAB_SIZE_EIGHT with EXTENSION_TAB_SIZE_EIGHT)
+}
+
+// MarkdownWithOptions is like Markdown, but takes all of the parser's
+// settings rather than just the extensions.
+func MarkdownWithOptions(input []byte, renderer Renderer, options ParserOptions) []byte {
 	// no point in parsing if we can't render
 	if renderer == nil {
 		return nil
 	}
 
+	// positions are worked out for the nodes of a document tree, so only
+	// build one when the renderer wants them, or for CommonMark, which may
+	// parse a piece of the document more than once and throw away all but
+	// the last go; a renderer would see each of them
+	if wantsPositions(renderer) || options.Extensions&EXTENSION_COMMONMARK != 0 {
+		return parseTree(input, options, wantsPositions(renderer)).Render(renderer)
+	}
+
+	p := newParser(renderer, options)
+	var output bytes.Buffer
+	renderer.DocumentHeader(&output)
+	first, offsets := firstPass(p, input)
+	p.pushSource(first, offsets)
+	secondPass(p, &output, first)
+	p.popSource()
+	renderer.DocumentFooter(&output)
+
+	return output.Bytes()
+}
+
+// Create a parser that reports what it finds to renderer
+func newParser(renderer Renderer, options ParserOptions) *parser {
+	extensions := options.Extensions
+
 	// fill in the render structure
 	p := new(parser)
 	p.r = renderer
 	p.flags = extensions
 	p.refs = make(map[string]*reference)
-	p.maxNesting = 16
+	p.maxNesting = options.MaxNesting
+	if p.maxNesting <= 0 {
+		p.maxNesting = MAX_NESTING_DEFAULT
+	}
+	p.tabSize = options.TabSize
+	if p.tabSize <= 0 {
+		p.tabSize = TAB_SIZE_DEFAULT
+		if extensions&EXTENSION_TAB_SIZE_EIGHT != 0 {
+			p.tabSize = TAB_SIZE_EIGHT
+		}
+	}
 	p.insideLink = false
+	p.beg, p.last, p.codeBeg = -1, -1, -1
 
 	// register inline parsers
 	p.inlineCallback['*'] = emphasis
@@ -293,14 +375,20 @@ func Markdown(input []byte, renderer Renderer, extensions int) []byte {
 		p.inlineCallback[':'] = autoLink
 	}
 
+	if extensions&EXTENSION_COMMONMARK != 0 {
+		p.inlineCallback['*'] = delimiterRun
+		p.inlineCallback['_'] = delimiterRun
+		p.looseLists = make(map[int]bool)
+
+		// lists are told apart by where they are in the input
+		p.positions = true
+	}
+
 	if extensions&EXTENSION_FOOTNOTES != 0 {
 		p.notes = make([]*reference, 0)
 	}
 
-	first := firstPass(p, input)
-	second := secondPass(p, first)
-
-	return second
+	return p
 }
 
 // first pass:
@@ -308,12 +396,15 @@ func Markdown(input []byte, renderer Renderer, extensions int) []byte {
 // - expand tabs
 // - normalize newlines
 // - copy everything else
-func firstPass(p *parser, input []byte) []byte {
+// Returns the copy, and the input offset of each of its bytes.
+func firstPass(p *parser, input []byte) ([]byte, []int) {
 	var out bytes.Buffer
-	tabSize := TAB_SIZE_DEFAULT
-	if p.flags&EXTENSION_TAB_SIZE_EIGHT != 0 {
-		tabSize = TAB_SIZE_EIGHT
+	var offsets []int
+	if p.positions {
+		offsets = make([]int, 0, len(input)+1)
 	}
+	tabSize := p.tabSize
+	p.pushSource(input, nil)
 	beg, end := 0, 0
 	for beg < len(input) { // iterate over lines
 		if end = isReference(p, input[beg:], tabSize); end > 0 {
@@ -326,9 +417,12 @@ func firstPass(p *parser, input []byte) []byte {
 
 			// add the line body if present
 			if end > beg {
-				expandTabs(&out, input[beg:end], tabSize)
+				offsets = expandTabs(&out, offsets, input[beg:end], beg, tabSize)
 			}
 			out.WriteByte('\n')
+			if offsets != nil {
+				offsets = append(offsets, end)
+			}
 
 			if end < len(input) && input[end] == '\r' {
 				end++
@@ -340,34 +434,46 @@ func firstPass(p *parser, input []byte) []byte {
 			beg = end
 		}
 	}
+	p.popSource()
 
 	// empty input?
 	if out.Len() == 0 {
 		out.WriteByte('\n')
+		if offsets != nil {
+			offsets = append(offsets, 0)
+		}
 	}
 
-	return out.Bytes()
+	if offsets == nil {
+		return out.Bytes(), nil
+	}
+	return out.Bytes(), append(offsets, len(input))
 }
 
-// second pass: actual rendering
-func secondPass(p *parser, input []byte) []byte {
-	var output bytes.Buffer
-
-	p.r.DocumentHeader(&output)
-	p.block(&output, input)
+// second pass: actual parsing, rendering into output
+func secondPass(p *parser, output *bytes.Buffer, input []byte) {
+	if p.flags&EXTENSION_COMMONMARK != 0 {
+		p.commonMarkReferences(input)
+	}
+	p.document = output
+	p.block(output, input)
+	p.document = nil
 
 	if p.flags&EXTENSION_FOOTNOTES != 0 && len(p.notes) > 0 {
-		p.r.Footnotes(&output, func() bool {
+		p.r.Footnotes(output, func() bool {
 			flags := LIST_ITEM_BEGINNING_OF_LIST
 			for _, ref := range p.notes {
 				var buf bytes.Buffer
+				p.pushSource(ref.title, ref.offsets)
 				if ref.hasBlock {
 					flags |= LIST_ITEM_CONTAINS_BLOCK
 					p.block(&buf, ref.title)
 				} else {
 					p.inline(&buf, ref.title)
 				}
-				p.r.FootnoteItem(&output, ref.link, buf.Bytes(), flags)
+				p.at(ref.title, 0, len(ref.title))
+				p.popSource()
+				p.r.FootnoteItem(output, ref.link, buf.Bytes(), flags)
 				flags &^= LIST_ITEM_BEGINNING_OF_LIST | LIST_ITEM_CONTAINS_BLOCK
 			}
 
@@ -375,13 +481,9 @@ func secondPass(p *parser, input []byte) []byte {
 		})
 	}
 
-	p.r.DocumentFooter(&output)
-
 	if p.nesting != 0 {
 		panic("Nesting level did not end at zero")
 	}
-
-	return output.Bytes()
 }
 
 //
@@ -419,6 +521,7 @@ type reference struct {
 	title    []byte
 	noteId   int // 0 if not a footnote ref
 	hasBlock bool
+	offsets  []int // input offsets of the footnote text
 }
 
 // Check whether or not data starts with a reference link.
@@ -451,6 +554,12 @@ func isReference(p *parser, data []byte, tabSize int) int {
 			i++
 		}
 	}
+
+	// CommonMark link references are found along with the blocks, as
+	// they can't interrupt a paragraph
+	if noteId == 0 && p.flags&EXTENSION_COMMONMARK != 0 {
+		return 0
+	}
 	idOffset := i
 	for i < len(data) && data[i] != '\n' && data[i] != '\r' && data[i] != ']' {
 		i++
@@ -487,11 +596,12 @@ func isReference(p *parser, data []byte, tabSize int) int {
 		titleOffset, titleEnd int
 		lineEnd               int
 		raw                   []byte
+		offsets               []int
 		hasBlock              bool
 	)
 
 	if p.flags&EXTENSION_FOOTNOTES != 0 && noteId != 0 {
-		linkOffset, linkEnd, raw, hasBlock = scanFootnote(p, data, i, tabSize)
+		linkOffset, linkEnd, raw, offsets, hasBlock = scanFootnote(p, data, i, tabSize)
 		lineEnd = linkEnd
 	} else {
 		linkOffset, linkEnd, titleOffset, titleEnd, lineEnd = scanLinkRef(p, data, i)
@@ -512,13 +622,14 @@ func isReference(p *parser, data []byte, tabSize int) int {
 		ref.link = data[idOffset:idEnd]
 		// if footnote, it's not really a title, it's the contained text
 		ref.title = raw
+		ref.offsets = offsets
 	} else {
 		ref.link = data[linkOffset:linkEnd]
 		ref.title = data[titleOffset:titleEnd]
 	}
 
 	// id matches are case-insensitive
-	id := string(bytes.ToLower(data[idOffset:idEnd]))
+	id := p.referenceKey(data[idOffset:idEnd])
 
 	p.refs[id] = ref
 
@@ -599,7 +710,7 @@ func scanLinkRef(p *parser, data []byte, i int) (linkOffset, linkEnd, titleOffse
 // blockEnd is the end of the section in the input buffer, and contents is the
 // extracted text that was shifted over one tab. It will need to be rendered at
 // the end of the document.
-func scanFootnote(p *parser, data []byte, i, indentSize int) (blockStart, blockEnd int, contents []byte, hasBlock bool) {
+func scanFootnote(p *parser, data []byte, i, indentSize int) (blockStart, blockEnd int, contents []byte, offsets []int, hasBlock bool) {
 	if i == 0 || len(data) == 0 {
 		return
 	}
@@ -622,6 +733,7 @@ func scanFootnote(p *parser, data []byte, i, indentSize int) (blockStart, blockE
 
 	// put the first line into the working buffer
 	raw.Write(data[blockEnd:i])
+	offsets = p.appendOffsets(offsets, data, blockEnd, i)
 	blockEnd = i
 
 	// process the following lines
@@ -654,11 +766,13 @@ gatherLines:
 		// if there were blank lines before this one, insert a new one now
 		if containsBlankLine {
 			raw.WriteByte('\n')
+			offsets = append(offsets, p.sourceOffset(data, blockEnd-1))
 			containsBlankLine = false
 		}
 
 		// get rid of that first tab, write to buffer
 		raw.Write(data[blockEnd+n : i])
+		offsets = p.appendOffsets(offsets, data, blockEnd+n, i)
 		hasBlock = true
 
 		blockEnd = i
@@ -666,9 +780,11 @@ gatherLines:
 
 	if data[blockEnd-1] != '\n' {
 		raw.WriteByte('\n')
+		offsets = append(offsets, p.sourceOffset(data, blockEnd))
 	}
 
 	contents = raw.Bytes()
+	offsets = append(offsets, p.sourceOffset(data, blockEnd))
 
 	return
 }
@@ -707,8 +823,11 @@ func isalnum(c byte) bool {
 }
 
 // Replace tab characters with spaces, aligning to the next TAB_SIZE column.
-// always ends output with a newline
-func expandTabs(out *bytes.Buffer, line []byte, tabSize int) {
+// Returns offsets with the input offset of each byte written added to it,
+// where line starts at offset base, unless offsets is nil.
+func expandTabs(out *bytes.Buffer, offsets []int, line []byte, base, tabSize int) []int {
+	track := offsets != nil
+
 	// first, check for common cases: no tabs, or only tabs at beginning of line
 	i, prefix := 0, 0
 	slowcase := false
@@ -727,9 +846,15 @@ func expandTabs(out *bytes.Buffer, line []byte, tabSize int) {
 	if !slowcase {
 		for i = 0; i < prefix*tabSize; i++ {
 			out.WriteByte(' ')
+			if track {
+				offsets = append(offsets, base+i/tabSize)
+			}
 		}
 		out.Write(line[prefix:])
-		return
+		for i = prefix; track && i < len(line); i++ {
+			offsets = append(offsets, base+i)
+		}
+		return offsets
 	}
 
 	// the slow case: we need to count runes to figure out how
@@ -746,6 +871,9 @@ func expandTabs(out *bytes.Buffer, line []byte, tabSize int) {
 
 		if i > start {
 			out.Write(line[start:i])
+			for j := start; track && j < i; j++ {
+				offsets = append(offsets, base+j)
+			}
 		}
 
 		if i >= len(line) {
@@ -754,6 +882,9 @@ func expandTabs(out *bytes.Buffer, line []byte, tabSize int) {
 
 		for {
 			out.WriteByte(' ')
+			if track {
+				offsets = append(offsets, base+i)
+			}
 			column++
 			if column%tabSize == 0 {
 				break
@@ -762,6 +893,8 @@ func expandTabs(out *bytes.Buffer, line []byte, tabSize int) {
 
 		i++
 	}
+
+	return offsets
 }
 
 // Find if a line counts as indented or not.
@@ -0,0 +1,674 @@
+package blackfriday
+
+import (
+	"bytes"
+	"github.com/davidoram/blackfriday/flower"
+	"strconv"
+)
+
+// NodeType identifies the kind of element held in a Node
+type NodeType int
+
+// Node types, one for each element the parser recognises
+const (
+	NODE_DOCUMENT NodeType = iota
+	NODE_BLOCK_QUOTE
+	NODE_BLOCK_HTML
+	NODE_CODE_BLOCK
+	NODE_HEADING
+	NODE_HORIZONTAL_RULE
+	NODE_LIST
+	NODE_ITEM
+	NODE_PARAGRAPH
+	NODE_TABLE
+	NODE_TABLE_HEAD
+	NODE_TABLE_BODY
+	NODE_TABLE_ROW
+	NODE_TABLE_CELL
+	NODE_FOOTNOTES
+	NODE_FOOTNOTE_ITEM
+	NODE_AUTO_LINK
+	NODE_CODE_SPAN
+	NODE_DOUBLE_EMPHASIS
+	NODE_EMPHASIS
+	NODE_IMAGE
+	NODE_LINE_BREAK
+	NODE_LINK
+	NODE_RAW_HTML
+	NODE_TRIPLE_EMPHASIS
+	NODE_STRIKETHROUGH
+	NODE_FOOTNOTE_REF
+	NODE_ENTITY
+	NODE_TEXT
+)
+
+var nodeTypeNames = []string{
+	NODE_DOCUMENT:        "Document",
+	NODE_BLOCK_QUOTE:     "BlockQuote",
+	NODE_BLOCK_HTML:      "BlockHtml",
+	NODE_CODE_BLOCK:      "CodeBlock",
+	NODE_HEADING:         "Heading",
+	NODE_HORIZONTAL_RULE: "HorizontalRule",
+	NODE_LIST:            "List",
+	NODE_ITEM:            "Item",
+	NODE_PARAGRAPH:       "Paragraph",
+	NODE_TABLE:           "Table",
+	NODE_TABLE_HEAD:      "TableHead",
+	NODE_TABLE_BODY:      "TableBody",
+	NODE_TABLE_ROW:       "TableRow",
+	NODE_TABLE_CELL:      "TableCell",
+	NODE_FOOTNOTES:       "Footnotes",
+	NODE_FOOTNOTE_ITEM:   "FootnoteItem",
+	NODE_AUTO_LINK:       "AutoLink",
+	NODE_CODE_SPAN:       "CodeSpan",
+	NODE_DOUBLE_EMPHASIS: "DoubleEmphasis",
+	NODE_EMPHASIS:        "Emphasis",
+	NODE_IMAGE:           "Image",
+	NODE_LINE_BREAK:      "LineBreak",
+	NODE_LINK:            "Link",
+	NODE_RAW_HTML:        "RawHtml",
+	NODE_TRIPLE_EMPHASIS: "TripleEmphasis",
+	NODE_STRIKETHROUGH:   "StrikeThrough",
+	NODE_FOOTNOTE_REF:    "FootnoteRef",
+	NODE_ENTITY:          "Entity",
+	NODE_TEXT:            "Text",
+}
+
+// Return the name of the node type, eg "Paragraph"
+func (t NodeType) String() string {
+	if t < 0 || int(t) >= len(nodeTypeNames) {
+		return "NodeType(" + strconv.Itoa(int(t)) + ")"
+	}
+	return nodeTypeNames[t]
+}
+
+// Node is one element of the document tree returned by Parse.
+//
+// Which fields are used depends on the Type. Literal holds the raw content
+// of text, code, html and entity nodes, and the alt text of an image. The
+// remaining fields hold the values handed to the matching Renderer callback.
+type Node struct {
+	Type     NodeType
+	Parent   *Node
+	Children []*Node
+
+	Literal     []byte // content of a leaf node
+	Info        string // language of a code block
+	Destination []byte // target of a link, image or auto link, name of a footnote
+	Title       []byte // title of a link or image
+	Level       int    // level of a heading
+	Flags       int    // LIST_* flags of a list, item or footnote, LINK_TYPE_* of an auto link, TABLE_ALIGNMENT_* of a cell
+	Columns     []int  // alignment of each column of a table
+	NoteId      int    // number of a footnote reference
+
+	// Where the element was found in the input: the positions of its first
+	// and last bytes. These are not valid for nodes that were not parsed.
+	Start, End Position
+
+	// Where the text of a code block starts in the input, after its opening
+	// fence or its indentation. Not valid for other nodes.
+	CodeStart Position
+}
+
+// AppendChild adds child as the last child of node.
+func (node *Node) AppendChild(child *Node) {
+	child.Parent = node
+	node.Children = append(node.Children, child)
+}
+
+// Walk visits node and its descendants in document order. The visitor is
+// called once on entering each node and once on leaving it; returning false
+// on entry skips that node's children.
+func (node *Node) Walk(visitor func(node *Node, entering bool) bool) {
+	if visitor(node, true) {
+		for _, child := range node.Children {
+			child.Walk(visitor)
+		}
+	}
+	visitor(node, false)
+}
+
+// Render walks the tree rooted at node, driving renderer in the same order
+// that Markdown would, and returns the output. Rendering a Document node
+// includes the renderer's document header and footer.
+func (node *Node) Render(renderer Renderer) []byte {
+	var out bytes.Buffer
+	node.render(&out, renderer)
+	return out.Bytes()
+}
+
+// Parse parses a block of markdown-encoded text into a document tree,
+// ready to be rendered with Node.Render. The extensions are the same as
+// those accepted by Markdown.
+func Parse(input []byte, extensions int) *Node {
+	return ParseWithOptions(input, ParserOptions{Extensions: extensions})
+}
+
+// ParseWithOptions is like Parse, but takes all of the parser's settings
+// rather than just the extensions.
+func ParseWithOptions(input []byte, options ParserOptions) *Node {
+	return parseTree(input, options, true)
+}
+
+// Parse input into a document tree. The nodes are only given their
+// positions if positions is set
+func parseTree(input []byte, options ParserOptions, positions bool) *Node {
+	builder := new(treeBuilder)
+	p := newParser(builder, options)
+	builder.p = p
+	if positions {
+		p.positions = true
+	}
+	if p.positions {
+		builder.lines = newLineIndex(input)
+	}
+
+	var second bytes.Buffer
+	first, offsets := firstPass(p, input)
+	p.pushSource(first, offsets)
+	secondPass(p, &second, first)
+	p.popSource()
+
+	if p.positions {
+		p.beg, p.last = 0, len(input)-1
+	}
+	return builder.attach(builder.node(NODE_DOCUMENT), second.Bytes())
+}
+
+func (node *Node) render(out *bytes.Buffer, r Renderer) {
+	switch node.Type {
+	case NODE_DOCUMENT:
+		r.DocumentHeader(out)
+		node.renderChildren(out, r)
+		r.DocumentFooter(out)
+	case NODE_BLOCK_QUOTE:
+		text := node.contents(r)
+		node.position(r)
+		r.BlockQuote(out, text)
+	case NODE_BLOCK_HTML:
+		node.position(r)
+		r.BlockHtml(out, node.Literal)
+	case NODE_CODE_BLOCK:
+		node.position(r)
+		r.BlockCodeStart(out, node.Literal, node.Info)
+		r.BlockCodeBody(out, node.Literal, node.Info)
+		r.BlockCodeEnd(out, node.Literal, node.Info)
+	case NODE_HEADING:
+		node.position(r)
+		r.Header(out, node.text(out, r), node.Level)
+	case NODE_HORIZONTAL_RULE:
+		node.position(r)
+		r.HRule(out)
+	case NODE_LIST:
+		node.position(r)
+		r.List(out, node.text(out, r), node.Flags)
+	case NODE_ITEM:
+		// strip trailing newlines
+		text := node.contents(r)
+		end := len(text)
+		for end > 0 && text[end-1] == '\n' {
+			end--
+		}
+		node.position(r)
+		r.ListItem(out, text[:end], node.Flags)
+	case NODE_PARAGRAPH:
+		node.position(r)
+		r.Paragraph(out, node.text(out, r))
+	case NODE_TABLE:
+		var header, body bytes.Buffer
+		for _, child := range node.Children {
+			switch child.Type {
+			case NODE_TABLE_HEAD:
+				child.renderChildren(&header, r)
+			case NODE_TABLE_BODY:
+				child.renderChildren(&body, r)
+			}
+		}
+		node.position(r)
+		r.Table(out, header.Bytes(), body.Bytes(), node.Columns)
+	case NODE_TABLE_HEAD, NODE_TABLE_BODY:
+		node.renderChildren(out, r)
+	case NODE_TABLE_ROW:
+		text := node.contents(r)
+		node.position(r)
+		r.TableRow(out, text)
+	case NODE_TABLE_CELL:
+		text := node.contents(r)
+		node.position(r)
+		r.TableCell(out, text, node.Flags)
+	case NODE_FOOTNOTES:
+		node.position(r)
+		r.Footnotes(out, node.text(out, r))
+	case NODE_FOOTNOTE_ITEM:
+		text := node.contents(r)
+		node.position(r)
+		r.FootnoteItem(out, node.Destination, text, node.Flags)
+	case NODE_AUTO_LINK:
+		node.position(r)
+		r.AutoLink(out, node.Destination, node.Flags)
+	case NODE_CODE_SPAN:
+		node.position(r)
+		r.CodeSpanStart(out, node.Literal)
+		r.CodeSpanBody(out, node.Literal)
+		r.CodeSpanEnd(out, node.Literal)
+	case NODE_DOUBLE_EMPHASIS:
+		text := node.contents(r)
+		node.position(r)
+		r.DoubleEmphasis(out, text)
+	case NODE_EMPHASIS:
+		text := node.contents(r)
+		node.position(r)
+		r.Emphasis(out, text)
+	case NODE_IMAGE:
+		node.position(r)
+		r.Image(out, node.Destination, node.Title, node.Literal)
+	case NODE_LINE_BREAK:
+		node.position(r)
+		r.LineBreak(out)
+	case NODE_LINK:
+		text := node.contents(r)
+		node.position(r)
+		r.Link(out, node.Destination, node.Title, text)
+	case NODE_RAW_HTML:
+		node.position(r)
+		r.RawHtmlTag(out, node.Literal)
+	case NODE_TRIPLE_EMPHASIS:
+		text := node.contents(r)
+		node.position(r)
+		r.TripleEmphasis(out, text)
+	case NODE_STRIKETHROUGH:
+		text := node.contents(r)
+		node.position(r)
+		r.StrikeThrough(out, text)
+	case NODE_FOOTNOTE_REF:
+		node.position(r)
+		r.FootnoteRef(out, node.Destination, node.NoteId)
+	case NODE_ENTITY:
+		node.position(r)
+		r.Entity(out, node.Literal)
+	case NODE_TEXT:
+		node.position(r)
+		r.NormalText(out, node.Literal)
+	}
+}
+
+func (node *Node) renderChildren(out *bytes.Buffer, r Renderer) {
+	for _, child := range node.Children {
+		child.render(out, r)
+	}
+}
+
+// Render the children into a buffer of their own, for the callbacks that
+// take the rendered contents of an element
+func (node *Node) contents(r Renderer) []byte {
+	var work bytes.Buffer
+	node.renderChildren(&work, r)
+	return work.Bytes()
+}
+
+// Render the children straight into out, for the callbacks that take a
+// function to write the contents of an element
+func (node *Node) text(out *bytes.Buffer, r Renderer) func() bool {
+	return func() bool {
+		node.renderChildren(out, r)
+		return true
+	}
+}
+
+//
+// Building the tree
+//
+// The parser was written to call a Renderer as it goes, and relies on being
+// able to look back over (and trim) the text it has already written. To keep
+// that working, Parse hands it a treeBuilder, which writes text to the output
+// as-is and every other element as a reference to a node. Whenever the parser
+// passes a buffer back in as the contents of an element, the buffer is
+// decoded into that element's children.
+//
+// Literal text never contains a zero byte, so markers are set off by them:
+//
+//    \x00t<id>\x00  starts text chunk number <id>, followed by its text
+//    \x00z\x00      a zero byte within the text
+//    \x00n<id>\x00  refers to node number <id>
+//
+
+const (
+	treeText      = 't'
+	treeZero      = 'z'
+	treeReference = 'n'
+)
+
+// treeBuilder is a Renderer that records what the parser finds as Nodes
+type treeBuilder struct {
+	p     *parser
+	lines lineIndex
+	nodes []*Node
+	texts [][]int // input offsets of each text chunk
+
+	// how many of the nodes and text chunks have been let go of
+	releasedNodes, releasedTexts int
+}
+
+// Create a node, positioned where the parser last said it was working
+func (b *treeBuilder) node(kind NodeType) *Node {
+	node := &Node{Type: kind}
+	if b.p.beg >= 0 {
+		node.Start = b.lines.position(b.p.beg)
+		node.End = b.lines.position(b.p.last)
+	}
+	b.p.beg, b.p.last = -1, -1
+	return node
+}
+
+// Write a marker to out
+func (b *treeBuilder) mark(out *bytes.Buffer, kind byte, id int) {
+	out.WriteByte(0)
+	out.WriteByte(kind)
+	if id >= 0 {
+		out.WriteString(strconv.Itoa(id))
+	}
+	out.WriteByte(0)
+}
+
+// Write a reference to node in place of its rendered output
+func (b *treeBuilder) add(out *bytes.Buffer, node *Node) {
+	b.mark(out, treeReference, len(b.nodes))
+	b.nodes = append(b.nodes, node)
+}
+
+// Decode the output the parser wrote into children of parent
+func (b *treeBuilder) attach(parent *Node, data []byte) *Node {
+	var text *Node
+	var texts []*Node
+	var offsets [][]int
+
+	for i := 0; i < len(data); {
+		if data[i] != 0 {
+			end := i
+			for end < len(data) && data[end] != 0 {
+				end++
+			}
+			if text == nil {
+				text = &Node{Type: NODE_TEXT}
+				parent.AppendChild(text)
+			}
+			text.Literal = append(text.Literal, data[i:end]...)
+			i = end
+			continue
+		}
+
+		end := i + 2
+		for data[end] != 0 {
+			end++
+		}
+		id, _ := strconv.Atoi(string(data[i+2 : end]))
+
+		switch data[i+1] {
+		case treeText:
+			text = &Node{Type: NODE_TEXT}
+			parent.AppendChild(text)
+			texts = append(texts, text)
+			offsets = append(offsets, b.texts[id])
+		case treeZero:
+			if text == nil {
+				text = &Node{Type: NODE_TEXT}
+				parent.AppendChild(text)
+			}
+			text.Literal = append(text.Literal, 0)
+		case treeReference:
+			parent.AppendChild(b.nodes[id])
+			text = nil
+		}
+		i = end + 1
+	}
+
+	// the parser may have trimmed the end off text, but not the start
+	for i, text := range texts {
+		if n := len(text.Literal); n > 0 && n < len(offsets[i]) {
+			text.Start = b.lines.position(offsets[i][0])
+			text.End = b.lines.position(offsets[i][n-1])
+		}
+	}
+
+	// and it may have trimmed some text away completely
+	children := parent.Children[:0]
+	for _, child := range parent.Children {
+		if child.Type != NODE_TEXT || len(child.Literal) > 0 {
+			children = append(children, child)
+		}
+	}
+	parent.Children = children
+
+	return parent
+}
+
+// Let go of the nodes and text chunks created so far, once everything the
+// parser wrote has been attached to the tree, so that they can be freed
+// along with it
+func (b *treeBuilder) release() {
+	for ; b.releasedNodes < len(b.nodes); b.releasedNodes++ {
+		b.nodes[b.releasedNodes] = nil
+	}
+	for ; b.releasedTexts < len(b.texts); b.releasedTexts++ {
+		b.texts[b.releasedTexts] = nil
+	}
+}
+
+// Run a callback that writes the contents of an element straight into out,
+// and take back what it wrote as the children of a new node
+func (b *treeBuilder) capture(out *bytes.Buffer, kind NodeType, text func() bool) *Node {
+	marker := out.Len()
+	text()
+	node := b.attach(b.node(kind), out.Bytes()[marker:])
+	out.Truncate(marker)
+	return node
+}
+
+// Remove n characters of text from the end of out, in the way the parser
+// expects when it takes back text that it has already written
+func rewindText(out *bytes.Buffer, n int) {
+	for n > 0 {
+		data := out.Bytes()
+		end := len(data)
+		if end == 0 {
+			return
+		}
+		if data[end-1] != 0 {
+			out.Truncate(end - 1)
+			n--
+			continue
+		}
+
+		// step back over a marker
+		start := bytes.LastIndexByte(data[:end-1], 0)
+		switch data[start+1] {
+		case treeText:
+			// the text is empty, so carry on with the text before it
+		case treeZero:
+			n--
+		default:
+			return
+		}
+		out.Truncate(start)
+	}
+}
+
+func (b *treeBuilder) CommandTagStart(out *bytes.Buffer, command flower.Command) {}
+
+func (b *treeBuilder) CommandTagEnd(out *bytes.Buffer, command flower.Command) {}
+
+func (b *treeBuilder) BlockCodeStart(out *bytes.Buffer, text []byte, lang string) {
+	node := b.node(NODE_CODE_BLOCK)
+	node.Literal = text
+	node.Info = lang
+	if b.p.codeBeg >= 0 {
+		node.CodeStart = b.lines.position(b.p.codeBeg)
+	}
+	b.p.codeBeg = -1
+	b.add(out, node)
+}
+
+func (b *treeBuilder) BlockCodeBody(out *bytes.Buffer, text []byte, lang string) {}
+
+func (b *treeBuilder) BlockCodeEnd(out *bytes.Buffer, text []byte, lang string) {}
+
+func (b *treeBuilder) BlockQuote(out *bytes.Buffer, text []byte) {
+	b.add(out, b.attach(b.node(NODE_BLOCK_QUOTE), text))
+}
+
+func (b *treeBuilder) BlockHtml(out *bytes.Buffer, text []byte) {
+	node := b.node(NODE_BLOCK_HTML)
+	node.Literal = text
+	b.add(out, node)
+}
+
+func (b *treeBuilder) Header(out *bytes.Buffer, text func() bool, level int) {
+	node := b.capture(out, NODE_HEADING, text)
+	node.Level = level
+	b.add(out, node)
+}
+
+func (b *treeBuilder) HRule(out *bytes.Buffer) {
+	b.add(out, b.node(NODE_HORIZONTAL_RULE))
+}
+
+func (b *treeBuilder) List(out *bytes.Buffer, text func() bool, flags int) {
+	node := b.capture(out, NODE_LIST, text)
+	node.Flags = flags
+	b.add(out, node)
+}
+
+func (b *treeBuilder) ListItem(out *bytes.Buffer, text []byte, flags int) {
+	node := b.attach(b.node(NODE_ITEM), text)
+	node.Flags = flags
+	b.add(out, node)
+}
+
+func (b *treeBuilder) Paragraph(out *bytes.Buffer, text func() bool) {
+	b.add(out, b.capture(out, NODE_PARAGRAPH, text))
+}
+
+func (b *treeBuilder) Table(out *bytes.Buffer, header []byte, body []byte, columnData []int) {
+	table := b.node(NODE_TABLE)
+	table.Columns = columnData
+	table.AppendChild(b.attach(&Node{Type: NODE_TABLE_HEAD}, header))
+	table.AppendChild(b.attach(&Node{Type: NODE_TABLE_BODY}, body))
+	b.add(out, table)
+}
+
+func (b *treeBuilder) TableRow(out *bytes.Buffer, text []byte) {
+	b.add(out, b.attach(b.node(NODE_TABLE_ROW), text))
+}
+
+func (b *treeBuilder) TableCell(out *bytes.Buffer, text []byte, flags int) {
+	node := b.attach(b.node(NODE_TABLE_CELL), text)
+	node.Flags = flags
+	b.add(out, node)
+}
+
+func (b *treeBuilder) Footnotes(out *bytes.Buffer, text func() bool) {
+	b.add(out, b.capture(out, NODE_FOOTNOTES, text))
+}
+
+func (b *treeBuilder) FootnoteItem(out *bytes.Buffer, name, text []byte, flags int) {
+	node := b.attach(b.node(NODE_FOOTNOTE_ITEM), text)
+	node.Destination = name
+	node.Flags = flags
+	b.add(out, node)
+}
+
+func (b *treeBuilder) AutoLink(out *bytes.Buffer, link []byte, kind int) {
+	node := b.node(NODE_AUTO_LINK)
+	node.Destination = link
+	node.Flags = kind
+	b.add(out, node)
+}
+
+func (b *treeBuilder) CodeSpanStart(out *bytes.Buffer, text []byte) {
+	node := b.node(NODE_CODE_SPAN)
+	node.Literal = text
+	b.add(out, node)
+}
+
+func (b *treeBuilder) CodeSpanBody(out *bytes.Buffer, text []byte) {}
+
+func (b *treeBuilder) CodeSpanEnd(out *bytes.Buffer, text []byte) {}
+
+func (b *treeBuilder) DoubleEmphasis(out *bytes.Buffer, text []byte) {
+	b.add(out, b.attach(b.node(NODE_DOUBLE_EMPHASIS), text))
+}
+
+func (b *treeBuilder) Emphasis(out *bytes.Buffer, text []byte) {
+	b.add(out, b.attach(b.node(NODE_EMPHASIS), text))
+}
+
+func (b *treeBuilder) Image(out *bytes.Buffer, link []byte, title []byte, alt []byte) {
+	node := b.node(NODE_IMAGE)
+	node.Destination = link
+	node.Title = title
+	node.Literal = alt
+	b.add(out, node)
+}
+
+func (b *treeBuilder) LineBreak(out *bytes.Buffer) {
+	b.add(out, b.node(NODE_LINE_BREAK))
+}
+
+func (b *treeBuilder) Link(out *bytes.Buffer, link []byte, title []byte, content []byte) {
+	node := b.attach(b.node(NODE_LINK), content)
+	node.Destination = link
+	node.Title = title
+	b.add(out, node)
+}
+
+func (b *treeBuilder) RawHtmlTag(out *bytes.Buffer, tag []byte) {
+	node := b.node(NODE_RAW_HTML)
+	node.Literal = tag
+	b.add(out, node)
+}
+
+func (b *treeBuilder) TripleEmphasis(out *bytes.Buffer, text []byte) {
+	b.add(out, b.attach(b.node(NODE_TRIPLE_EMPHASIS), text))
+}
+
+func (b *treeBuilder) StrikeThrough(out *bytes.Buffer, text []byte) {
+	b.add(out, b.attach(b.node(NODE_STRIKETHROUGH), text))
+}
+
+func (b *treeBuilder) FootnoteRef(out *bytes.Buffer, ref []byte, id int) {
+	node := b.node(NODE_FOOTNOTE_REF)
+	node.Destination = ref
+	node.NoteId = id
+	b.add(out, node)
+}
+
+func (b *treeBuilder) Entity(out *bytes.Buffer, entity []byte) {
+	node := b.node(NODE_ENTITY)
+	node.Literal = entity
+	b.add(out, node)
+}
+
+func (b *treeBuilder) NormalText(out *bytes.Buffer, text []byte) {
+	if len(text) == 0 {
+		return
+	}
+
+	// text is always a slice of the buffer being parsed
+	b.mark(out, treeText, len(b.texts))
+	b.texts = append(b.texts, b.p.sourceOffsets(text))
+
+	for {
+		i := bytes.IndexByte(text, 0)
+		if i < 0 {
+			out.Write(text)
+			return
+		}
+		out.Write(text[:i])
+		b.mark(out, treeZero, -1)
+		text = text[i+1:]
+	}
+}
+
+func (b *treeBuilder) DocumentHeader(out *bytes.Buffer) {}
+
+func (b *treeBuilder) DocumentFooter(out *bytes.Buffer) {}
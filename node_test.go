@@ -0,0 +1,176 @@
+//
+// Unit tests for the document tree
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Write the tree out in a compact form that is easy to compare
+func dumpTree(out *bytes.Buffer, node *Node) {
+	out.WriteString(node.Type.String())
+	switch node.Type {
+	case NODE_HEADING:
+		out.WriteString(strconv.Itoa(node.Level))
+	case NODE_CODE_BLOCK:
+		out.WriteString(":" + node.Info)
+	case NODE_LINK, NODE_IMAGE, NODE_AUTO_LINK:
+		out.WriteString("<" + string(node.Destination) + ">")
+	}
+	if len(node.Literal) > 0 {
+		out.WriteString(strconv.Quote(string(node.Literal)))
+	}
+	if len(node.Children) > 0 {
+		out.WriteString("(")
+		for i, child := range node.Children {
+			if i > 0 {
+				out.WriteString(" ")
+			}
+			if child.Parent != node {
+				out.WriteString("!")
+			}
+			dumpTree(out, child)
+		}
+		out.WriteString(")")
+	}
+}
+
+func doTestsTree(t *testing.T, tests []string, extensions int) {
+	for i := 0; i+1 < len(tests); i += 2 {
+		var out bytes.Buffer
+		dumpTree(&out, Parse([]byte(tests[i]), extensions))
+		if actual := out.String(); actual != tests[i+1] {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]",
+				tests[i], tests[i+1], actual)
+		}
+	}
+}
+
+func TestParseTree(t *testing.T) {
+	var tests = []string{
+		"# Title\n\nSome *emphasis* and **strong** text.\n",
+		"Document(Heading1(Text\"Title\") Paragraph(Text\"Some \" Emphasis(Text\"emphasis\") Text\" and \" DoubleEmphasis(Text\"strong\") Text\" text.\"))",
+
+		"* one\n* two\n",
+		"Document(List(Item(Text\"one\") Item(Text\"two\")))",
+
+		"A [link](http://example.com/) and ![alt](/img.png)\n",
+		"Document(Paragraph(Text\"A \" Link<http://example.com/>(Text\"link\") Text\" and \" Image</img.png>\"alt\"))",
+
+		"> quoted `code`\n",
+		"Document(BlockQuote(Paragraph(Text\"quoted \" CodeSpan\"code\")))",
+
+		"    indented\n",
+		"Document(CodeBlock:\"indented\\n\")",
+
+		"line  \nbreak &amp; <b>tag</b>\n",
+		"Document(Paragraph(Text\"line\" LineBreak Text\"break \" Entity\"&amp;\" Text\" \" RawHtml\"<b>\" Text\"tag\" RawHtml\"</b>\"))",
+
+		"zero\x00byte\n",
+		"Document(Paragraph(Text\"zero\\x00byte\"))",
+	}
+	doTestsTree(t, tests, 0)
+}
+
+func TestParseTreeExtensions(t *testing.T) {
+	var tests = []string{
+		"``` go\nfmt.Println()\n```\n",
+		"Document(CodeBlock:go\"fmt.Println()\\n\")",
+
+		"a | b\n---|---\n1 | 2\n",
+		"Document(Table(TableHead(TableRow(TableCell(Text\"a\") TableCell(Text\"b\"))) TableBody(TableRow(TableCell(Text\"1\") TableCell(Text\"2\")))))",
+
+		"see http://example.com/ now\n",
+		"Document(Paragraph(Text\"see \" AutoLink<http://example.com/> Text\" now\"))",
+
+		"~~gone~~\n",
+		"Document(Paragraph(StrikeThrough(Text\"gone\")))",
+
+		"Title\n=====\n",
+		"Document(Heading1(Text\"Title\"))",
+	}
+	doTestsTree(t, tests, EXTENSION_FENCED_CODE|EXTENSION_TABLES|EXTENSION_AUTOLINK|EXTENSION_STRIKETHROUGH)
+}
+
+func TestParseTreeFootnotes(t *testing.T) {
+	var tests = []string{
+		"Text[^1].\n\n[^1]: The note.\n",
+		"Document(Paragraph(Text\"Text\" FootnoteRef Text\".\") Footnotes(FootnoteItem(Text\"The note.\" Text\"\\n\")))",
+	}
+	doTestsTree(t, tests, EXTENSION_FOOTNOTES)
+}
+
+func TestRenderTree(t *testing.T) {
+	renderer := HtmlRenderer(HTML_USE_XHTML, "", "")
+	doc := Parse([]byte("# Title\n\nSome *text* here.\n"), 0)
+
+	// change the tree before rendering it
+	doc.Walk(func(node *Node, entering bool) bool {
+		if entering && node.Type == NODE_TEXT {
+			node.Literal = bytes.ToUpper(node.Literal)
+		}
+		return true
+	})
+	expected := "<h1>TITLE</h1>\n\n<p>SOME <em>TEXT</em> HERE.</p>\n"
+	if actual := string(doc.Render(renderer)); actual != expected {
+		t.Errorf("Expected[%#v]\nActual  [%#v]", expected, actual)
+	}
+
+	// a single node renders without the document around it
+	expected = "<p>SOME <em>TEXT</em> HERE.</p>\n"
+	if actual := string(doc.Children[1].Render(renderer)); actual != expected {
+		t.Errorf("Expected[%#v]\nActual  [%#v]", expected, actual)
+	}
+}
+
+// Markdown renders straight from the parser, so check that the tree renders
+// the same way
+func TestRenderTreeMatchesMarkdown(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("upskirtref", "*.text"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("No reference files: %v", err)
+	}
+	renderers := []func() Renderer{
+		func() Renderer { return HtmlRenderer(0, "", "") },
+		func() Renderer { return HtmlRenderer(HTML_TOC|HTML_USE_SMARTYPANTS, "", "") },
+		func() Renderer { return LatexRenderer(0) },
+	}
+	for _, file := range files {
+		input, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, renderer := range renderers {
+			for _, extensions := range []int{0, EXTENSION_FOOTNOTES | EXTENSION_TABLES | EXTENSION_FENCED_CODE, EXTENSION_COMMONMARK} {
+				expected := string(Markdown(input, renderer(), extensions))
+				if actual := string(Parse(input, extensions).Render(renderer())); actual != expected {
+					t.Errorf("%s, renderer %d, extensions %d\nExpected[%#v]\nActual  [%#v]", file, i, extensions, expected, actual)
+				}
+			}
+		}
+	}
+}
+
+func TestWalkSkipsChildren(t *testing.T) {
+	doc := Parse([]byte("Some *emphasis* here.\n\n> *quoted*\n"), 0)
+
+	var visited []string
+	doc.Walk(func(node *Node, entering bool) bool {
+		if entering {
+			visited = append(visited, node.Type.String())
+		}
+		return node.Type != NODE_BLOCK_QUOTE
+	})
+
+	expected := "Document Paragraph Text Emphasis Text Text BlockQuote"
+	if actual := strings.Join(visited, " "); actual != expected {
+		t.Errorf("Expected[%#v]\nActual  [%#v]", expected, actual)
+	}
+}
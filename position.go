@@ -0,0 +1,180 @@
+package blackfriday
+
+import (
+	"sort"
+	"strconv"
+)
+
+// Position is a place in the markdown input
+type Position struct {
+	Offset int // byte offset, starting at 0
+	Line   int // line number, starting at 1
+	Column int // byte offset within the line, starting at 1
+}
+
+// Return true if the position was found in the input
+func (pos Position) IsValid() bool {
+	return pos.Line > 0
+}
+
+// Return the position as "line:column"
+func (pos Position) String() string {
+	return strconv.Itoa(pos.Line) + ":" + strconv.Itoa(pos.Column)
+}
+
+// SourcePositioner is implemented by renderers that want to know where each
+// element came from. When Node.Render drives such a renderer, it calls
+// SourcePos with the element's first and last positions just before the
+// callback that renders it. Elements without a known position get invalid
+// positions. Markdown renders through a document tree for these renderers,
+// so that they are told the positions too.
+type SourcePositioner interface {
+	SourcePos(start, end Position)
+}
+
+// Implemented by SourcePositioners that only use the positions some of the
+// time, such as the HTML renderer without HTML_SOURCEPOS
+type sourcePosWanter interface {
+	wantsSourcePos() bool
+}
+
+// Implemented by SourcePositioners that also want to know where the text of
+// each code block starts, such as the Flower renderer. When Node.Render
+// drives one, it calls codePos with the block's CodeStart just after
+// SourcePos
+type codePositioner interface {
+	codePos(start Position)
+}
+
+// Return true if the renderer wants to know where each element came from
+func wantsPositions(r Renderer) bool {
+	if _, ok := r.(SourcePositioner); !ok {
+		return false
+	}
+	if wanter, ok := r.(sourcePosWanter); ok {
+		return wanter.wantsSourcePos()
+	}
+	return true
+}
+
+// Tell the renderer where node came from, if it wants to know
+func (node *Node) position(r Renderer) {
+	if positioner, ok := r.(SourcePositioner); ok {
+		positioner.SourcePos(node.Start, node.End)
+	}
+	if positioner, ok := r.(codePositioner); ok && node.Type == NODE_CODE_BLOCK {
+		positioner.codePos(node.CodeStart)
+	}
+}
+
+// A buffer that the parser works on, with the input offset of each of its
+// bytes. Buffers are either the input itself, the output of the first pass,
+// or built up from pieces of those (such as the contents of a block quote
+// with the prefixes removed).
+type source struct {
+	data    []byte
+	offsets []int // one more than len(data), or nil if data is the input
+}
+
+// Start working on a new buffer
+func (p *parser) pushSource(data []byte, offsets []int) {
+	if p.positions {
+		p.sources = append(p.sources, source{data, offsets})
+	}
+}
+
+// Go back to the buffer that was being worked on before
+func (p *parser) popSource() {
+	if p.positions {
+		p.sources = p.sources[:len(p.sources)-1]
+	}
+}
+
+// Return the input offset of data[i], or -1 if it isn't known. data must
+// have been sliced from the buffer currently being worked on.
+func (p *parser) sourceOffset(data []byte, i int) int {
+	if len(p.sources) == 0 {
+		return -1
+	}
+	s := p.sources[len(p.sources)-1]
+
+	// data shares its end with the buffer, so this is where it starts
+	k := cap(s.data) - cap(data) + i
+	switch {
+	case k < 0 || k > len(s.data):
+		return -1
+	case s.offsets == nil:
+		return k
+	}
+	return s.offsets[k]
+}
+
+// Return the input offsets of each byte of data and of its end, or nil if
+// they aren't known. data must have been sliced from the buffer currently
+// being worked on.
+func (p *parser) sourceOffsets(data []byte) []int {
+	if len(p.sources) == 0 {
+		return nil
+	}
+	s := p.sources[len(p.sources)-1]
+	k := cap(s.data) - cap(data)
+	if s.offsets == nil || k < 0 || k+len(data) > len(s.data) {
+		return nil
+	}
+	return s.offsets[k : k+len(data)+1]
+}
+
+// Return the input offsets of data[beg:end] added to offsets, for a new
+// buffer being built up from them
+func (p *parser) appendOffsets(offsets []int, data []byte, beg, end int) []int {
+	for i := beg; p.positions && i < end; i++ {
+		offsets = append(offsets, p.sourceOffset(data, i))
+	}
+	return offsets
+}
+
+// Note that the next element handed to the renderer covers data[beg:end],
+// ignoring any trailing newlines
+func (p *parser) at(data []byte, beg, end int) {
+	if !p.positions {
+		return
+	}
+	for end > beg && data[end-1] == '\n' {
+		end--
+	}
+	p.beg = p.sourceOffset(data, beg)
+	p.last = p.beg
+	if end > beg {
+		p.last = p.sourceOffset(data, end-1)
+	}
+}
+
+// Note that the text of the next code block handed to the renderer starts at
+// data[i], after any fence and indentation
+func (p *parser) codeAt(data []byte, i int) {
+	if p.positions {
+		p.codeBeg = p.sourceOffset(data, i)
+	}
+}
+
+// The start of each line of the input
+type lineIndex []int
+
+func newLineIndex(input []byte) lineIndex {
+	lines := lineIndex{0}
+	for i, c := range input {
+		if c == '\n' || (c == '\r' && (i+1 == len(input) || input[i+1] != '\n')) {
+			lines = append(lines, i+1)
+		}
+	}
+	return lines
+}
+
+// Return the position of the byte at offset
+func (lines lineIndex) position(offset int) Position {
+	if offset < 0 {
+		return Position{}
+	}
+	line := sort.Search(len(lines), func(i int) bool { return lines[i] > offset }) - 1
+	return Position{Offset: offset, Line: line + 1, Column: offset - lines[line] + 1}
+}
@@ -0,0 +1,136 @@
+//
+// Unit tests for source positions
+//
+
+package blackfriday
+
+import (
+	"strings"
+	"testing"
+)
+
+// List the blocks of the tree with where each came from
+func dumpPositions(doc *Node) string {
+	var blocks []string
+	doc.Walk(func(node *Node, entering bool) bool {
+		if entering && node.Type != NODE_DOCUMENT {
+			blocks = append(blocks, node.Type.String()+" "+node.Start.String()+"-"+node.End.String())
+		}
+		return true
+	})
+	return strings.Join(blocks, "\n")
+}
+
+func doTestsPositions(t *testing.T, tests []string, extensions int) {
+	for i := 0; i+1 < len(tests); i += 2 {
+		actual := dumpPositions(Parse([]byte(tests[i]), extensions))
+		if actual != tests[i+1] {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]",
+				tests[i], tests[i+1], actual)
+		}
+	}
+}
+
+func TestPositions(t *testing.T) {
+	var tests = []string{
+		"# Title\n\nSome *em*\ntext\n",
+		"Heading 1:1-1:7\nText 1:3-1:7\n" +
+			"Paragraph 3:1-4:4\nText 3:1-3:5\nEmphasis 3:6-3:9\nText 3:7-3:8\nText 3:10-4:4",
+
+		// tabs are expanded, but columns count bytes of the input
+		"\tcode\n\n***\n",
+		"CodeBlock 1:1-1:5\nHorizontalRule 3:1-3:3",
+
+		"line one\r\nline  \r\ntwo\r\n",
+		"Paragraph 1:1-3:3\nText 1:1-1:8\nText 1:9-2:4\nLineBreak 2:7-2:7\nText 3:1-3:3",
+
+		"> * one\n>   two\n>\n> * three\n",
+		"BlockQuote 1:1-4:9\nList 1:3-4:9\n" +
+			"Item 1:3-2:7\nParagraph 1:5-2:7\nText 1:5-1:7\nText 1:8-2:7\n" +
+			"Item 4:3-4:9\nParagraph 4:5-4:9\nText 4:5-4:9",
+
+		"a ![b](/c) [d](/e) `f`\n",
+		"Paragraph 1:1-1:22\nText 1:1-1:2\nImage 1:3-1:10\nText 1:11-1:11\n" +
+			"Link 1:12-1:18\nText 1:13-1:13\nText 1:19-1:19\nCodeSpan 1:20-1:22",
+	}
+	doTestsPositions(t, tests, 0)
+}
+
+func TestPositionsExtensions(t *testing.T) {
+	var tests = []string{
+		"Title\n=====\n",
+		"Heading 1:1-2:5\nText 1:1-1:5",
+
+		"``` go\nx\n```\n",
+		"CodeBlock 1:1-3:3",
+
+		"a | b\n---|---\n1 | 2\n",
+		"Table 1:1-3:5\nTableHead 0:0-0:0\n" +
+			"TableRow 1:1-1:5\nTableCell 1:1-1:1\nText 1:1-1:1\nTableCell 1:5-1:5\nText 1:5-1:5\n" +
+			"TableBody 0:0-0:0\n" +
+			"TableRow 3:1-3:5\nTableCell 3:1-3:1\nText 3:1-3:1\nTableCell 3:5-3:5\nText 3:5-3:5",
+
+		"Text[^1].\n\n[^1]: The note.\n",
+		"Paragraph 1:1-1:9\nText 1:1-1:4\nFootnoteRef 1:5-1:8\nText 1:9-1:9\n" +
+			"Footnotes 0:0-0:0\nFootnoteItem 3:7-3:15\nText 3:7-3:15\nText 3:16-3:16",
+	}
+	doTestsPositions(t, tests, EXTENSION_FENCED_CODE|EXTENSION_TABLES|EXTENSION_FOOTNOTES)
+}
+
+func TestLineIndex(t *testing.T) {
+	lines := newLineIndex([]byte("ab\ncd\r\nef\rg"))
+	var tests = []struct {
+		offset   int
+		expected string
+	}{
+		{0, "1:1"}, {2, "1:3"}, {3, "2:1"}, {6, "2:4"},
+		{7, "3:1"}, {9, "3:3"}, {10, "4:1"}, {-1, "0:0"},
+	}
+	for _, test := range tests {
+		if actual := lines.position(test.offset).String(); actual != test.expected {
+			t.Errorf("Offset %d\nExpected[%#v]\nActual  [%#v]", test.offset, test.expected, actual)
+		}
+	}
+}
+
+func TestHtmlSourcePos(t *testing.T) {
+	var tests = []string{
+		"# Title\n\n* one\n* two\n",
+		"<h1 data-sourcepos=\"1:1-1:7\">Title</h1>\n\n" +
+			"<ul data-sourcepos=\"3:1-4:5\">\n<li data-sourcepos=\"3:1-3:5\">one</li>\n" +
+			"<li data-sourcepos=\"4:1-4:5\">two</li>\n</ul>\n",
+
+		"> quoted *text*\n\n---\n",
+		"<blockquote data-sourcepos=\"1:1-1:15\">\n<p data-sourcepos=\"1:3-1:15\">quoted <em>text</em></p>\n</blockquote>\n\n" +
+			"<hr data-sourcepos=\"3:1-3:3\">\n",
+
+		"    code\n",
+		"<pre data-sourcepos=\"1:1-1:8\"><code>code\n</code></pre>\n",
+
+		// trailing blank lines are not part of a code block
+		"    one\n\n    two\n    \n\ntext\n",
+		"<pre data-sourcepos=\"1:1-3:7\"><code>one\n\ntwo\n</code></pre>\n\n<p data-sourcepos=\"6:1-6:4\">text</p>\n",
+	}
+	for i := 0; i+1 < len(tests); i += 2 {
+		renderer := HtmlRenderer(HTML_SOURCEPOS, "", "")
+		actual := string(Markdown([]byte(tests[i]), renderer, 0))
+		if actual != tests[i+1] {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]",
+				tests[i], tests[i+1], actual)
+		}
+	}
+
+	// nothing changes without the flag
+	input := []byte("# Title\n\ntext\n")
+	expected := "<h1>Title</h1>\n\n<p>text</p>\n"
+	if actual := string(Markdown(input, HtmlRenderer(0, "", ""), 0)); actual != expected {
+		t.Errorf("Expected[%#v]\nActual  [%#v]", expected, actual)
+	}
+
+	// the rule and list around the footnotes aren't in the input
+	input = []byte("Text[^1].\n\n[^1]: The note.\n")
+	renderer := HtmlRenderer(HTML_SOURCEPOS, "", "")
+	if actual := string(Markdown(input, renderer, EXTENSION_FOOTNOTES)); !strings.Contains(actual, "<hr>\n\n<ol>") {
+		t.Errorf("Footnotes have source positions\nActual  [%#v]", actual)
+	}
+}
@@ -2,17 +2,41 @@ package blackfriday
 
 import (
 	"bytes"
-	//"fmt"
+	"crypto/rand"
+	"encoding/hex"
 	"github.com/davidoram/blackfriday/flower"
+	"strconv"
 	"strings"
 )
 
+// Marks the place in the output where the tag for a flower command will go,
+// once the command has been executed. Followed by the document's token, the
+// command number and a terminating zero byte.
+var commandTagPlaceholder = []byte("\x00flower:")
+
+// Marks the place in the output where a graph of the flower commands will go,
+// once they have been executed. Followed by the document's token, the graph's
+// format and a terminating zero byte.
+var graphPlaceholder = []byte("\x00flower-graph:")
+
+// The languages of fenced code blocks that are replaced with a graph of the
+// flower commands, and the format each is drawn in
+var graphLanguages = map[string]string{
+	"flower-dot":     "dot",
+	"flower-mermaid": "mermaid",
+}
+
 // Flower is a type that implements the Renderer interface
 //
 // Do not create this directly, instead use the FlowerRenderer function.
 type Flower struct {
 	renderer    Renderer                    // the renderer that we are wrapping,
 	interpreter *flower.StandardInterpreter // parses code blocks for flower directives
+	commands    []flower.Command            // commands waiting for their tags to be filled in
+	graphs      int                         // graphs waiting to be drawn
+	token       []byte                      // new for each document, so its placeholders can't be forged
+	start, end  Position                    // where the element being rendered is
+	code        Position                    // where the text of the code block being rendered starts
 }
 
 // WrappedRenderer creates and configures an Renderer object, which
@@ -21,39 +45,101 @@ type Flower struct {
 // as markdown which is passed on to the wrapped
 // Renderer which performs the end user rendering
 func WrappedRenderer(wrapped_renderer Renderer) Renderer {
+	return FlowerRenderer(wrapped_renderer, flower.NewInterpreter())
+}
 
+// FlowerRenderer is like WrappedRenderer, but evaluates the flower commands
+// with the interpreter supplied, so that it can be configured by the caller
+// and inspected once rendering is complete.
+func FlowerRenderer(wrapped_renderer Renderer, interpreter *flower.StandardInterpreter) *Flower {
 	return &Flower{
 		renderer:    wrapped_renderer,
-		interpreter: flower.NewInterpreter(),
+		interpreter: interpreter,
 	}
 }
 
 // Surround HTML code with tags that can be used to identify and style the flower command contained within
+//
+// Commands are not executed until the whole document has been parsed, so a
+// placeholder is written here and replaced with the wrapped renderer's tag
+// by DocumentFooter.
 func (options *Flower) CommandTagStart(out *bytes.Buffer, command flower.Command) {
-	options.renderer.CommandTagStart(out, command)
+	if command == nil {
+		options.renderer.CommandTagStart(out, command)
+		return
+	}
+	out.Write(commandTagPlaceholder)
+	out.Write(options.documentToken())
+	out.WriteString(strconv.Itoa(len(options.commands)))
+	out.WriteByte(0)
+	options.commands = append(options.commands, command)
 }
 
 func (options *Flower) CommandTagEnd(out *bytes.Buffer, command flower.Command) {
 	options.renderer.CommandTagEnd(out, command)
 }
 
+// Pass source positions on to the wrapped renderer, if it wants them. They
+// are kept so that diagnostics can say where in the document a directive is
+func (options *Flower) SourcePos(start, end Position) {
+	options.start, options.end = start, end
+	options.code = Position{}
+	if positioner, ok := options.renderer.(SourcePositioner); ok {
+		positioner.SourcePos(start, end)
+	}
+}
+
 
 // block-level callbacks
 func (options *Flower) BlockCodeStart(out *bytes.Buffer, text []byte, lang string) {
+	if format, ok := graphLanguages[lang]; ok {
+		lang = format
+	}
 	options.renderer.BlockCodeStart(out, text, lang)
 }
 
+// A code block in one of the graph languages is replaced by a graph of all the
+// flower commands in the document, so its own text is ignored
 func (options *Flower) BlockCodeBody(out *bytes.Buffer, text []byte, lang string) {
+	if format, ok := graphLanguages[lang]; ok {
+		out.Write(graphPlaceholder)
+		out.Write(options.documentToken())
+		out.WriteString(format)
+		out.WriteByte(0)
+		options.graphs++
+		return
+	}
+	options.codePosition()
 	lines := strings.Split(string(text[:]), "\n")
-	for _, line := range lines {
-		command := options.interpreter.EvaluateCode(line)
-		options.renderer.CommandTagStart(out, command)
+	for i, line := range lines {
+		// the text ends with a newline, which is not a line of its own
+		var command flower.Command
+		if i < len(lines)-1 || line != "" {
+			command = options.interpreter.EvaluateCode(line)
+		}
+		options.CommandTagStart(out, command)
 		options.renderer.BlockCodeBody(out, []byte(line), lang)
 		options.renderer.CommandTagEnd(out, command)
 	}
 }
 
+// Keep where the text of the code block about to be rendered starts, so
+// that diagnostics can say where its directives are
+func (options *Flower) codePos(start Position) {
+	options.code = start
+}
+
+// Tell the interpreter where the lines of a code block are in the document
+func (options *Flower) codePosition() {
+	if options.code.IsValid() {
+		options.interpreter.SetPosition(options.code.Line, options.code.Column)
+	}
+}
+
 func (options *Flower) BlockCodeEnd(out *bytes.Buffer, text []byte, lang string) {
+	if format, ok := graphLanguages[lang]; ok {
+		lang = format
+	}
 	options.renderer.BlockCodeEnd(out, text, lang)
 }
 
@@ -113,11 +199,16 @@ func (options *Flower) CodeSpanStart(out *bytes.Buffer, text []byte) {
 	options.renderer.CodeSpanStart(out, text)
 }
 
+// The position of a code span is that of its opening backtick, so the
+// columns of its diagnostics are right when there is just one
 func (options *Flower) CodeSpanBody(out *bytes.Buffer, text []byte) {
+	if options.start.IsValid() {
+		options.interpreter.SetPosition(options.start.Line, options.start.Column+1)
+	}
 	lines := strings.Split(string(text[:]), "\n")
 	for _, line := range lines {
 		command := options.interpreter.EvaluateCode(line)
-		options.renderer.CommandTagStart(out, command)
+		options.CommandTagStart(out, command)
 		options.renderer.CodeSpanBody(out, []byte(line))
 		options.renderer.CommandTagEnd(out, command)
 	}
@@ -165,8 +256,125 @@ func (options *Flower) NormalText(out *bytes.Buffer, text []byte) {
 
 // Header and footer
 func (options *Flower) DocumentHeader(out *bytes.Buffer) {
+	options.token = nil
 	options.renderer.DocumentHeader(out)
 }
+
+// Return the token that follows the placeholders in this document. The input
+// may contain zero bytes, so a placeholder must also carry something the
+// document can't know
+func (options *Flower) documentToken() []byte {
+	if options.token == nil {
+		random := make([]byte, 16)
+		if _, err := rand.Read(random); err != nil {
+			panic(err)
+		}
+		options.token = []byte(hex.EncodeToString(random) + ":")
+	}
+	return options.token
+}
+
+// The output is final up to the first flower command or graph, whose tags
+// are only filled in once the commands have been run
+func (options *Flower) Streaming() bool {
+	streamer, ok := options.renderer.(Streamer)
+	return ok && streamer.Streaming() && len(options.commands) == 0 && options.graphs == 0
+}
+
 func (options *Flower) DocumentFooter(out *bytes.Buffer) {
+	options.interpreter.Run()
+	options.fillCommandTags(out)
+	options.fillGraphs(out)
 	options.renderer.DocumentFooter(out)
 }
+
+// Return what a placeholder holds after the document's token, or false if it
+// doesn't carry the token, so isn't one of ours
+func (options *Flower) placeholderValue(text []byte) (string, bool) {
+	if options.token == nil || !bytes.HasPrefix(text, options.token) {
+		return "", false
+	}
+	return string(text[len(options.token):]), true
+}
+
+// Replace each command tag placeholder with the tag from the wrapped renderer,
+// now that the results of the commands are known
+func (options *Flower) fillCommandTags(out *bytes.Buffer) {
+	if len(options.commands) == 0 {
+		return
+	}
+
+	var filled bytes.Buffer
+	data := out.Bytes()
+	for {
+		start := bytes.Index(data, commandTagPlaceholder)
+		if start < 0 {
+			break
+		}
+		end := bytes.IndexByte(data[start+len(commandTagPlaceholder):], 0)
+		if end < 0 {
+			break
+		}
+		end += start + len(commandTagPlaceholder)
+
+		filled.Write(data[:start])
+		value, ours := options.placeholderValue(data[start+len(commandTagPlaceholder) : end])
+		id, err := strconv.Atoi(value)
+		if ours && err == nil && id < len(options.commands) {
+			options.renderer.CommandTagStart(&filled, options.commands[id])
+		} else {
+			// not one of ours, leave it alone
+			filled.Write(data[start : end+1])
+		}
+		data = data[end+1:]
+	}
+	filled.Write(data)
+
+	out.Reset()
+	out.Write(filled.Bytes())
+	options.commands = nil
+}
+
+// Replace each graph placeholder with a graph of the flower commands, drawn
+// as the body of a code block by the wrapped renderer
+func (options *Flower) fillGraphs(out *bytes.Buffer) {
+	if options.graphs == 0 {
+		return
+	}
+
+	results := options.interpreter.Results()
+	var filled bytes.Buffer
+	data := out.Bytes()
+	for {
+		start := bytes.Index(data, graphPlaceholder)
+		if start < 0 {
+			break
+		}
+		end := bytes.IndexByte(data[start+len(graphPlaceholder):], 0)
+		if end < 0 {
+			break
+		}
+		end += start + len(graphPlaceholder)
+
+		filled.Write(data[:start])
+		format, _ := options.placeholderValue(data[start+len(graphPlaceholder) : end])
+		var graph bytes.Buffer
+		switch format {
+		case "dot":
+			flower.WriteDOT(&graph, results)
+			options.renderer.BlockCodeBody(&filled, graph.Bytes(), format)
+		case "mermaid":
+			flower.WriteMermaid(&graph, results)
+			options.renderer.BlockCodeBody(&filled, graph.Bytes(), format)
+		default:
+			// not one of ours, leave it alone
+			filled.Write(data[start : end+1])
+		}
+		data = data[end+1:]
+	}
+	filled.Write(data)
+
+	out.Reset()
+	out.Write(filled.Bytes())
+	options.graphs = 0
+}
@@ -0,0 +1,160 @@
+//
+// Unit tests for the flower renderer
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/davidoram/blackfriday/flower"
+)
+
+func runMarkdownFlower(input string, interpreter *flower.StandardInterpreter) string {
+	renderer := FlowerRenderer(HtmlRenderer(HTML_USE_XHTML, "", ""), interpreter)
+	return string(Markdown([]byte(input), renderer, EXTENSION_FENCED_CODE))
+}
+
+func TestFlowerCommandTagsInDocumentOrder(t *testing.T) {
+	input := "    flower: localhost offers http:1\n    flower: localhost offers http:2\n\n" +
+		"* item\n\n        flower: localhost offers http:3\n"
+
+	prober := flower.NewScriptedProber()
+	prober.AddLocal("127.0.0.1")
+	prober.AddHost("localhost", "127.0.0.1")
+	prober.Open("tcp", "127.0.0.1:1")
+	prober.Open("tcp", "127.0.0.1:3")
+
+	interpreter := flower.NewInterpreter()
+	interpreter.SetConcurrency(3)
+	interpreter.SetProber(prober)
+	actual := runMarkdownFlower(input, interpreter)
+
+	if strings.Contains(actual, "\x00") {
+		t.Errorf("Placeholder left in output [%#v]", actual)
+	}
+	last := -1
+	for _, tag := range []string{"FLOWER-OK\" title=\"host:localhost, port:1,", "FLOWER-FAIL\" title=\"host:localhost, port:2,", "FLOWER-OK\" title=\"host:localhost, port:3,"} {
+		i := strings.Index(actual, tag)
+		if i < 0 {
+			t.Errorf("Missing tag %s in [%#v]", tag, actual)
+			continue
+		}
+		if i < last {
+			t.Errorf("Tag %s out of order in [%#v]", tag, actual)
+		}
+		last = i
+	}
+	if count := strings.Count(actual, "<div class="); count != 3 {
+		t.Errorf("Expected 3 command tags, got %d in [%#v]", count, actual)
+	}
+}
+
+func TestFlowerIgnoresForeignPlaceholders(t *testing.T) {
+	renderer := FlowerRenderer(HtmlRenderer(0, "", ""), flower.NewInterpreter())
+	renderer.commands = []flower.Command{nil}
+
+	var out bytes.Buffer
+	out.WriteString("a\x00flower:7\x00b")
+	renderer.fillCommandTags(&out)
+	if out.String() != "a\x00flower:7\x00b" {
+		t.Errorf("Unexpected output [%#v]", out.String())
+	}
+}
+
+func TestFlowerForgedPlaceholders(t *testing.T) {
+	input := "    flower: web uses postgres:5432 at db\n\n" +
+		"a\x00flower:0\x00 b\x00flower-graph:dot\x00\n\n" +
+		"    \x00flower:0\x00\n"
+	interpreter := flower.NewInterpreter()
+	interpreter.SetDryRun(true)
+	renderer := FlowerRenderer(HtmlRenderer(0, "", ""), interpreter)
+	actual := string(Markdown([]byte(input), renderer, 0))
+	if count := strings.Count(actual, "<div class="); count != 1 {
+		t.Errorf("Expected 1 command tag, got %d in [%#v]", count, actual)
+	}
+	if strings.Contains(actual, "digraph") {
+		t.Errorf("Unexpected graph in [%#v]", actual)
+	}
+}
+
+func TestFlowerGraph(t *testing.T) {
+	input := "```flower-dot\n```\n\n    flower: web uses postgres:5432 at db\n\n" +
+		"```flower-mermaid\nignored\n```\n"
+
+	prober := flower.NewScriptedProber()
+	prober.AddLocal("10.0.0.1")
+	prober.AddHost("web", "10.0.0.1")
+	prober.AddHost("db", "10.0.0.2")
+	prober.Open("tcp", "10.0.0.2:5432")
+
+	interpreter := flower.NewInterpreter()
+	interpreter.SetProber(prober)
+	actual := runMarkdownFlower(input, interpreter)
+
+	if strings.Contains(actual, "\x00") {
+		t.Errorf("Placeholder left in output [%#v]", actual)
+	}
+	for _, expected := range []string{
+		"<code class=\"dot\">digraph flower {",
+		"&quot;web&quot; -&gt; &quot;db&quot; [label=&quot;postgres:5432&quot;, color=green];",
+		"<code class=\"mermaid\">flowchart LR",
+		"n0 --&gt;|&quot;postgres:5432&quot;| n1",
+	} {
+		if !strings.Contains(actual, expected) {
+			t.Errorf("Expected %s in [%#v]", expected, actual)
+		}
+	}
+	if strings.Contains(actual, "ignored") {
+		t.Errorf("Graph block text left in output [%#v]", actual)
+	}
+}
+
+func TestFlowerDiagnostics(t *testing.T) {
+	input := "```\nflower: web offers http\nflower: web offer http\n```\n\n    flower: web offers http:99999\n\n\n" +
+		"See `flower: web offerz http`.\n"
+
+	interpreter := flower.NewInterpreter()
+	interpreter.SetDryRun(true)
+	actual := runMarkdownFlower(input, interpreter)
+
+	for _, expected := range []string{
+		"<div class=\"FLOWER-SYNTAX FLOWER-ERROR\" title=\"syntax error: line 3, column 13: unknown verb &quot;offer&quot;\" >flower: web offer http" +
+			"<span class=\"FLOWER-DIAGNOSTIC\"> &larr; line 3, column 13: unknown verb &quot;offer&quot;</span></div>",
+		"&larr; line 6, column 29: port out of range</span></div>",
+		"&larr; line 9, column 18: unknown verb &quot;offerz&quot;</span>",
+	} {
+		if !strings.Contains(actual, expected) {
+			t.Errorf("Expected %s in [%#v]", expected, actual)
+		}
+	}
+	if count := len(interpreter.Diagnostics()); count != 3 {
+		t.Errorf("Expected 3 diagnostics, got %d", count)
+	}
+}
+
+func TestFlowerDiagnosticsCodeStart(t *testing.T) {
+	tests := []struct {
+		input      string
+		extensions int
+		expected   string
+	}{
+		// indented with a tab
+		{"Text\n\n\tflower: web offer http\n", 0, "line 3, column 14: unknown verb"},
+		// a CommonMark fence without a closing one runs to the end
+		{"```\nflower: web offer http\n\n", EXTENSION_COMMONMARK, "line 2, column 13: unknown verb"},
+		{"> ```\n> \n> flower: web offer http\n", EXTENSION_COMMONMARK, "line 3, column 15: unknown verb"},
+	}
+	for _, test := range tests {
+		interpreter := flower.NewInterpreter()
+		interpreter.SetDryRun(true)
+		renderer := FlowerRenderer(HtmlRenderer(0, "", ""), interpreter)
+		Markdown([]byte(test.input), renderer, test.extensions)
+		diagnostics := interpreter.Diagnostics()
+		if len(diagnostics) != 1 || !strings.HasPrefix(diagnostics[0].String(), test.expected) {
+			t.Errorf("Input [%#v], expected %s, got %v", test.input, test.expected, diagnostics)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package blackfriday
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+)
+
+// Streamer is implemented by renderers that can say whether the output they
+// have written so far is final. Render only writes output out before the
+// document is finished while the renderer says it can; renderers that don't
+// implement Streamer may rewrite their output in DocumentFooter, so all of
+// it is held back until then.
+type Streamer interface {
+	Streaming() bool
+}
+
+// Render is like Markdown, but reads the input from r and writes the output
+// to w. Each top-level block is rendered as soon as it has been parsed, and
+// written out if the renderer implements Streamer and allows it, so the
+// rendered document is never held in memory as a whole.
+//
+// The input is still read in full before parsing starts, since links can
+// refer to definitions further down. Renderers that need the whole document,
+// such as the HTML renderer with HTML_TOC, get it: nothing is written until
+// the document footer is.
+func Render(w io.Writer, r io.Reader, renderer Renderer, extensions int) error {
+	return RenderWithOptions(w, r, renderer, ParserOptions{Extensions: extensions})
+}
+
+// RenderWithOptions is like Render, but takes all of the parser's settings
+// rather than just the extensions.
+func RenderWithOptions(w io.Writer, r io.Reader, renderer Renderer, options ParserOptions) error {
+	input, err := ioutil.ReadAll(r)
+	if err != nil || renderer == nil {
+		return err
+	}
+
+	builder := new(treeBuilder)
+	p := newParser(builder, options)
+	builder.p = p
+	if wantsPositions(renderer) {
+		p.positions = true
+	}
+	if p.positions {
+		builder.lines = newLineIndex(input)
+		p.beg, p.last = 0, len(input)-1
+	}
+	s := &stream{w: w, renderer: renderer, builder: builder, doc: builder.node(NODE_DOCUMENT)}
+	p.flush = s.flush
+
+	var blocks bytes.Buffer
+	renderer.DocumentHeader(&s.out)
+	first, offsets := firstPass(p, input)
+	p.pushSource(first, offsets)
+	secondPass(p, &blocks, first)
+	s.flush(&blocks)
+	p.popSource()
+	renderer.DocumentFooter(&s.out)
+
+	s.write(s.out.Len())
+	return s.err
+}
+
+// stream renders a document a few top-level blocks at a time
+type stream struct {
+	w        io.Writer
+	renderer Renderer
+	builder  *treeBuilder
+	doc      *Node
+	out      bytes.Buffer // rendered output that hasn't been written yet
+	err      error        // the first error from w
+}
+
+// Render the blocks the parser has written to blocks since the last call,
+// and write out as much of the output as the renderer allows
+func (s *stream) flush(blocks *bytes.Buffer) {
+	if s.err == nil {
+		s.builder.attach(s.doc, blocks.Bytes())
+		s.doc.renderChildren(&s.out, s.renderer)
+	}
+	blocks.Reset()
+	s.doc.Children = nil
+	s.builder.release()
+
+	// renderers look back to see whether anything has been written yet,
+	// so the last byte stays behind
+	if streamer, ok := s.renderer.(Streamer); ok && streamer.Streaming() {
+		s.write(s.out.Len() - 1)
+	}
+}
+
+// Write the first n bytes of the output
+func (s *stream) write(n int) {
+	if s.err == nil && n > 0 {
+		_, s.err = s.w.Write(s.out.Next(n))
+	}
+}
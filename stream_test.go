@@ -0,0 +1,133 @@
+//
+// Unit tests for streaming output
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/davidoram/blackfriday/flower"
+)
+
+// A writer that keeps each write separately
+type writeRecorder struct {
+	writes []string
+}
+
+func (w *writeRecorder) Write(data []byte) (int, error) {
+	w.writes = append(w.writes, string(data))
+	return len(data), nil
+}
+
+type failingIO struct{}
+
+func (failingIO) Read(data []byte) (int, error) {
+	return 0, errors.New("read failed")
+}
+
+func (failingIO) Write(data []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestRenderMatchesMarkdown(t *testing.T) {
+	var inputs = []string{
+		"",
+		"# Title\n\nSome *text*.\n\n* one\n* two\n\n---\n\n    code\n",
+		"Text[^1] and more[^2].\n\n[^1]: The note.\n[^2]: Another.\n\n## More\n\nText.\n",
+		"Link to [a][] defined later.\n\n> quoted\n\n[a]: /url \"title\"\n",
+		"# One\n\ntext\n\n## Two\n\n### Three\n\ntext\n\n# Four\n",
+		"- a\n- b\n\n  c\n- d\n\n<div>\nhtml\n</div>\n\n*emph **strong***\n",
+	}
+	var configs = []struct {
+		htmlFlags, extensions int
+	}{
+		{0, 0},
+		{HTML_USE_XHTML, EXTENSION_FOOTNOTES | EXTENSION_TABLES | EXTENSION_FENCED_CODE},
+		{HTML_TOC, EXTENSION_FOOTNOTES},
+		{HTML_TOC | HTML_OMIT_CONTENTS, 0},
+		{HTML_COMPLETE_PAGE | HTML_TOC, 0},
+		{HTML_COMPLETE_PAGE | HTML_SOURCEPOS, EXTENSION_FOOTNOTES},
+		{0, EXTENSION_COMMONMARK},
+	}
+
+	for _, input := range inputs {
+		for _, config := range configs {
+			expected := string(Markdown([]byte(input), HtmlRenderer(config.htmlFlags, "title", ""), config.extensions))
+			var out bytes.Buffer
+			err := Render(&out, strings.NewReader(input), HtmlRenderer(config.htmlFlags, "title", ""), config.extensions)
+			if err != nil || out.String() != expected {
+				t.Errorf("\nInput   [%#v] (flags %d, extensions %d)\nExpected[%#v]\nActual  [%#v]\nError   %v",
+					input, config.htmlFlags, config.extensions, expected, out.String(), err)
+			}
+		}
+
+		expected := string(Markdown([]byte(input), LatexRenderer(0), 0))
+		var out bytes.Buffer
+		if err := Render(&out, strings.NewReader(input), LatexRenderer(0), 0); err != nil || out.String() != expected {
+			t.Errorf("\nInput   [%#v] (LaTeX)\nExpected[%#v]\nActual  [%#v]\nError   %v",
+				input, expected, out.String(), err)
+		}
+	}
+}
+
+func TestRenderStreams(t *testing.T) {
+	input := "# Title\n\nOne.\n\nTwo.\n\nThree.\n"
+
+	// each block is written once the next one has been parsed
+	var w writeRecorder
+	if err := Render(&w, strings.NewReader(input), HtmlRenderer(0, "", ""), 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.writes) != 5 {
+		t.Errorf("Expected 5 writes, got %#v", w.writes)
+	}
+
+	// the table of contents goes first, so nothing can be written before
+	// the end
+	w = writeRecorder{}
+	if err := Render(&w, strings.NewReader(input), HtmlRenderer(HTML_TOC, "", ""), 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.writes) != 1 {
+		t.Errorf("Expected 1 write with HTML_TOC, got %#v", w.writes)
+	}
+}
+
+func TestRenderStreamsFlower(t *testing.T) {
+	prober := flower.NewScriptedProber()
+	prober.AddLocal("127.0.0.1")
+	prober.AddHost("localhost", "127.0.0.1")
+	prober.Open("tcp", "127.0.0.1:1")
+	interpreter := flower.NewInterpreter()
+	interpreter.SetProber(prober)
+	renderer := FlowerRenderer(HtmlRenderer(0, "", ""), interpreter)
+
+	// output is held back from the first command, whose tag isn't known
+	// until the commands have run
+	var w writeRecorder
+	input := "Before.\n\n    flower: localhost offers http:1\n\nAfter.\n"
+	if err := Render(&w, strings.NewReader(input), renderer, 0); err != nil {
+		t.Fatal(err)
+	}
+	actual := strings.Join(w.writes, "")
+	if len(w.writes) != 2 || !strings.HasPrefix(w.writes[0], "<p>Before.</p>") {
+		t.Errorf("Expected the first paragraph on its own, got %#v", w.writes)
+	}
+	if strings.Contains(actual, "\x00") || !strings.Contains(actual, "FLOWER-OK") {
+		t.Errorf("Command tag not filled in [%#v]", actual)
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	var out bytes.Buffer
+	if err := Render(&out, failingIO{}, HtmlRenderer(0, "", ""), 0); err == nil || out.Len() > 0 {
+		t.Errorf("Read error not returned: %v, output [%#v]", err, out.String())
+	}
+	if err := Render(failingIO{}, strings.NewReader("One.\n\nTwo.\n"), HtmlRenderer(0, "", ""), 0); err == nil {
+		t.Errorf("Write error not returned")
+	}
+}